@@ -0,0 +1,166 @@
+package mux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderRequestID = "X-Request-ID"
+)
+
+type AccessLogFormat uint8
+
+const (
+	AccessLogCommon AccessLogFormat = iota
+	AccessLogCombined
+	AccessLogJSON
+)
+
+// FieldLogger receives a message with alternating key/value pairs. A
+// *slog.Logger satisfies this interface.
+type FieldLogger interface {
+	Info(msg string, args ...interface{})
+}
+
+type AccessLogConfig struct {
+	// Output receives one line per request. Defaults to os.Stderr.
+	Output io.Writer
+	Format AccessLogFormat
+	// Logger, if set, receives the entry as fields instead of Output.
+	Logger FieldLogger
+}
+
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	RemoteIP  string        `json:"remoteIP"`
+	User      string        `json:"user,omitempty"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Proto     string        `json:"proto"`
+	Route     string        `json:"route,omitempty"`
+	Status    int           `json:"status"`
+	Size      int64         `json:"size"`
+	Duration  time.Duration `json:"durationNs"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"userAgent,omitempty"`
+	RequestID string        `json:"requestID,omitempty"`
+}
+
+func (e AccessLogEntry) Fields() []interface{} {
+	return []interface{}{
+		"remote_ip", e.RemoteIP,
+		"user", e.User,
+		"method", e.Method,
+		"uri", e.URI,
+		"proto", e.Proto,
+		"route", e.Route,
+		"status", e.Status,
+		"size", e.Size,
+		"duration", e.Duration,
+		"referer", e.Referer,
+		"user_agent", e.UserAgent,
+		"request_id", e.RequestID,
+	}
+}
+
+func (e AccessLogEntry) Format(f AccessLogFormat) string {
+	switch f {
+	case AccessLogJSON:
+		bs, _ := json.Marshal(e)
+		return string(bs)
+	case AccessLogCombined:
+		return fmt.Sprintf("%s %q %q", e.common(), dash(e.Referer), dash(e.UserAgent))
+	default:
+		return e.common()
+	}
+}
+
+func (e AccessLogEntry) common() string {
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %d`,
+		dash(e.RemoteIP), dash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.URI, e.Proto, e.Status, e.Size)
+}
+
+func AccessLog(c AccessLogConfig) Decorator {
+	out := c.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	var mutex sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r = trackRoute(r)
+			rw := NewResponseWriter(w)
+
+			next.ServeHTTP(rw.Expose(), r)
+
+			e := AccessLogEntry{
+				Time:      start,
+				RemoteIP:  remoteIP(r),
+				User:      remoteUser(r),
+				Method:    r.Method,
+				URI:       r.RequestURI,
+				Proto:     r.Proto,
+				Route:     routePattern(r),
+				Status:    status(rw),
+				Size:      rw.Size(),
+				Duration:  time.Since(start),
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+				RequestID: requestID(w, r),
+			}
+			if e.URI == "" {
+				e.URI = r.URL.RequestURI()
+			}
+			if c.Logger != nil {
+				c.Logger.Info("request", e.Fields()...)
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			fmt.Fprintln(out, e.Format(c.Format))
+		})
+	}
+}
+
+// status returns the status sent by the handler. net/http sends 200 if the
+// handler did not write anything.
+func status(w *ResponseWriter) int {
+	if w.Status() == 0 {
+		return http.StatusOK
+	}
+	return w.Status()
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func remoteUser(r *http.Request) string {
+	if r.URL.User != nil {
+		return r.URL.User.Username()
+	}
+	if u, _, ok := r.BasicAuth(); ok {
+		return u
+	}
+	return ""
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,352 @@
+package mux
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderAge    = "Age"
+	HeaderXCache = "X-Cache"
+)
+
+type ResponseCacheConfig struct {
+	// TTL is used for responses without max-age or s-maxage (default 1m).
+	TTL time.Duration
+	// MaxEntries limits the number of cached responses (default 1000).
+	MaxEntries int
+	// MaxBytes limits the total size of cached responses (default 64MiB).
+	MaxBytes int64
+	// Vary lists the request headers that are part of the cache key. Responses
+	// varying on other headers are not cached, nor are responses to requests
+	// with an Authorization or Cookie header not listed here.
+	Vary []string
+}
+
+// NewResponseCache creates an in-process cache for GET and HEAD responses.
+// Entries are keyed by method, path, query and the configured Vary headers
+// and are evicted least recently used first once a limit is reached.
+func NewResponseCache(c ResponseCacheConfig) *ResponseCache {
+	if c.TTL <= 0 {
+		c.TTL = time.Minute
+	}
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = 1000
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = 64 << 20
+	}
+	return &ResponseCache{
+		config:   c,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		inflight: map[string]*cacheCall{},
+	}
+}
+
+type ResponseCache struct {
+	config   ResponseCacheConfig
+	mutex    sync.Mutex
+	lru      *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*cacheCall
+	size     int64
+}
+
+type cacheEntry struct {
+	key     string
+	path    string
+	status  int
+	header  http.Header
+	body    []byte
+	created time.Time
+	expires time.Time
+}
+
+func (e *cacheEntry) size() int64 {
+	n := int64(len(e.key) + len(e.body))
+	for k, vs := range e.header {
+		for _, v := range vs {
+			n += int64(len(k) + len(v))
+		}
+	}
+	return n
+}
+
+// cacheCall coalesces concurrent misses of the same key.
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+}
+
+func (c *ResponseCache) Decorate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !c.shared(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := c.key(r)
+		revalidate := containsFold(splitList(r.Header.Get(HeaderCacheControl)), "no-cache")
+		if !revalidate {
+			if e := c.get(key); e != nil {
+				c.serve(w, e, "HIT")
+				return
+			}
+		}
+
+		c.mutex.Lock()
+		call, waiting := c.inflight[key]
+		if !waiting {
+			call = &cacheCall{done: make(chan struct{})}
+			c.inflight[key] = call
+		}
+		c.mutex.Unlock()
+
+		if waiting {
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				return
+			}
+			if call.entry != nil {
+				c.serve(w, call.entry, "HIT")
+				return
+			}
+			// the response could not be shared
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &cacheRecorder{header: http.Header{}}
+		defer func() {
+			c.mutex.Lock()
+			delete(c.inflight, key)
+			c.mutex.Unlock()
+			close(call.done)
+		}()
+		next.ServeHTTP(rec, r)
+		if rec.snapshot == nil {
+			rec.WriteHeader(http.StatusOK)
+		}
+
+		e := &cacheEntry{
+			key:     key,
+			path:    r.URL.Path,
+			status:  rec.status,
+			header:  rec.snapshot,
+			body:    rec.body.Bytes(),
+			created: time.Now(),
+		}
+		if ttl, ok := c.ttl(e); ok {
+			e.expires = e.created.Add(ttl)
+			c.put(e)
+			call.entry = e
+		}
+		c.serve(w, e, "MISS")
+	})
+}
+
+// Invalidate removes every entry whose path starts with prefix.
+func (c *ResponseCache) Invalidate(prefix string) {
+	c.invalidate(func(path string) bool {
+		return strings.HasPrefix(path, prefix)
+	})
+}
+
+// InvalidateOn removes the entries concerned by the signals of m, using the
+// same prefix and topic matching as long-polling. The returned function stops
+// listening.
+func (c *ResponseCache) InvalidateOn(m *Manager) (cancel func()) {
+	return m.Listen(func(signal string) {
+		c.invalidate(func(path string) bool {
+			return m.matches(path, signal)
+		})
+	})
+}
+
+// Len returns the number of cached responses.
+func (c *ResponseCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// Size returns the approximate number of bytes held by the cache.
+func (c *ResponseCache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+func (c *ResponseCache) invalidate(match func(path string) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, el := range c.entries {
+		if match(el.Value.(*cacheEntry).path) {
+			c.remove(el)
+		}
+	}
+}
+
+// shared reports whether responses to r may be shared. Responses to requests
+// with credentials are not, unless the credentials are part of the key.
+func (c *ResponseCache) shared(r *http.Request) bool {
+	for _, h := range []string{"Authorization", "Cookie"} {
+		if r.Header.Get(h) != "" && !containsFold(c.config.Vary, h) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *ResponseCache) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(r.URL.Path)
+	if q := r.URL.Query(); len(q) > 0 {
+		b.WriteString("?")
+		b.WriteString(q.Encode())
+	}
+	for _, h := range c.config.Vary {
+		b.WriteString("\n")
+		b.WriteString(http.CanonicalHeaderKey(h))
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header[http.CanonicalHeaderKey(h)], ", "))
+	}
+	return b.String()
+}
+
+// ttl determines how long a response may be cached, honoring its
+// Cache-Control and Vary headers.
+func (c *ResponseCache) ttl(e *cacheEntry) (time.Duration, bool) {
+	switch e.status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return 0, false
+	}
+	if e.header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	for _, v := range splitList(strings.Join(e.header[HeaderVary], ",")) {
+		if v == "*" || !containsFold(c.config.Vary, v) {
+			return 0, false
+		}
+	}
+	ttl := c.config.TTL
+	maxAge, sMaxAge := -1, -1
+	for _, d := range splitList(strings.Join(e.header[HeaderCacheControl], ",")) {
+		name, value := d, ""
+		if i := strings.Index(d, "="); i >= 0 {
+			name, value = d[:i], strings.Trim(d[i+1:], `"`)
+		}
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return 0, false
+		case "max-age":
+			if n, err := strconv.Atoi(value); err == nil {
+				maxAge = n
+			}
+		case "s-maxage":
+			if n, err := strconv.Atoi(value); err == nil {
+				sMaxAge = n
+			}
+		}
+	}
+	if sMaxAge >= 0 {
+		ttl = time.Duration(sMaxAge) * time.Second
+	} else if maxAge >= 0 {
+		ttl = time.Duration(maxAge) * time.Second
+	}
+	return ttl, ttl > 0
+}
+
+func (c *ResponseCache) get(key string) *cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+func (c *ResponseCache) put(e *cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e.size() > c.config.MaxBytes {
+		return
+	}
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size()
+	for c.lru.Len() > c.config.MaxEntries || c.size > c.config.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry. The caller must hold the lock.
+func (c *ResponseCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size()
+}
+
+func (c *ResponseCache) serve(w http.ResponseWriter, e *cacheEntry, status string) {
+	h := w.Header()
+	for k, vs := range e.header {
+		h[k] = append([]string{}, vs...)
+	}
+	if status == "HIT" {
+		h.Set(HeaderAge, strconv.Itoa(int(time.Since(e.created)/time.Second)))
+	}
+	h.Set(HeaderXCache, status)
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// cacheRecorder records a response, ignoring header changes after the header
+// was written.
+type cacheRecorder struct {
+	header   http.Header
+	snapshot http.Header
+	status   int
+	body     bytes.Buffer
+}
+
+func (r *cacheRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *cacheRecorder) WriteHeader(statusCode int) {
+	if r.snapshot != nil {
+		return
+	}
+	r.status = statusCode
+	r.snapshot = cloneHeader(r.header)
+}
+
+func (r *cacheRecorder) Write(bs []byte) (int, error) {
+	if r.snapshot == nil {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.body.Write(bs)
+}
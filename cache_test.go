@@ -0,0 +1,232 @@
+package mux
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingHandler answers with the number of calls so far.
+func countingHandler(calls *int64, header map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(calls, 1)
+		for k, v := range header {
+			w.Header().Set(k, v)
+		}
+		fmt.Fprintf(w, "%s %d", r.URL.Path, n)
+	})
+}
+
+func cacheGet(h http.Handler, target string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestResponseCacheHit(t *testing.T) {
+	var calls int64
+	c := NewResponseCache(ResponseCacheConfig{})
+	h := c.Decorate(countingHandler(&calls, nil))
+
+	first := cacheGet(h, "/items?b=2&a=1", nil)
+	if got := first.Header().Get(HeaderXCache); got != "MISS" {
+		t.Fatalf("expected MISS, got %q", got)
+	}
+	second := cacheGet(h, "/items?a=1&b=2", nil)
+	if got := second.Header().Get(HeaderXCache); got != "HIT" {
+		t.Fatalf("expected HIT, got %q", got)
+	}
+	if second.Body.String() != first.Body.String() || second.Header().Get(HeaderAge) != "0" {
+		t.Fatalf("unexpected hit %q, Age %q", second.Body, second.Header().Get(HeaderAge))
+	}
+	if calls != 1 || c.Len() != 1 {
+		t.Fatalf("expected 1 call and entry, got %d and %d", calls, c.Len())
+	}
+	if got := cacheGet(h, "/items", map[string]string{HeaderCacheControl: "no-cache"}); got.Header().Get(HeaderXCache) != "MISS" || calls != 2 {
+		t.Fatalf("no-cache request was served from the cache")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/items?a=1&b=2", nil))
+	if w.Header().Get(HeaderXCache) != "" || calls != 3 {
+		t.Fatalf("POST was served from the cache")
+	}
+}
+
+func TestResponseCacheNotShared(t *testing.T) {
+	tests := []struct {
+		name    string
+		vary    []string
+		request map[string]string
+		header  map[string]string
+		status  int
+		cached  bool
+	}{
+		{"plain", nil, nil, nil, http.StatusOK, true},
+		{"authorization", nil, map[string]string{"Authorization": "Bearer a"}, nil, http.StatusOK, false},
+		{"authorization in vary", []string{"Authorization"}, map[string]string{"Authorization": "Bearer a"}, nil, http.StatusOK, true},
+		{"cookie", nil, map[string]string{"Cookie": "session=a"}, nil, http.StatusOK, false},
+		{"cookie in vary", []string{"Cookie"}, map[string]string{"Cookie": "session=a"}, nil, http.StatusOK, true},
+		{"set-cookie", nil, nil, map[string]string{"Set-Cookie": "a=b"}, http.StatusOK, false},
+		{"no-store", nil, nil, map[string]string{HeaderCacheControl: "no-store"}, http.StatusOK, false},
+		{"private", nil, nil, map[string]string{HeaderCacheControl: "private, max-age=60"}, http.StatusOK, false},
+		{"max-age=0", nil, nil, map[string]string{HeaderCacheControl: "max-age=0"}, http.StatusOK, false},
+		{"vary unlisted", nil, nil, map[string]string{HeaderVary: "Accept-Language"}, http.StatusOK, false},
+		{"vary listed", []string{"accept-language"}, nil, map[string]string{HeaderVary: "Accept-Language"}, http.StatusOK, true},
+		{"vary any", []string{"Accept-Language"}, nil, map[string]string{HeaderVary: "*"}, http.StatusOK, false},
+		{"not found", nil, nil, nil, http.StatusNotFound, true},
+		{"server error", nil, nil, nil, http.StatusInternalServerError, false},
+		{"created", nil, nil, nil, http.StatusCreated, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int64
+			c := NewResponseCache(ResponseCacheConfig{Vary: tt.vary})
+			h := c.Decorate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&calls, 1)
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			cacheGet(h, "/", tt.request)
+			cacheGet(h, "/", tt.request)
+			if cached := calls == 1; cached != tt.cached {
+				t.Fatalf("expected cached %v, handler called %d times", tt.cached, calls)
+			}
+		})
+	}
+}
+
+func TestResponseCacheVaryKey(t *testing.T) {
+	var calls int64
+	c := NewResponseCache(ResponseCacheConfig{Vary: []string{"Cookie"}})
+	h := c.Decorate(countingHandler(&calls, nil))
+	a := cacheGet(h, "/me", map[string]string{"Cookie": "session=a"})
+	b := cacheGet(h, "/me", map[string]string{"Cookie": "session=b"})
+	if a.Body.String() == b.Body.String() {
+		t.Fatal("responses for different cookies were shared")
+	}
+	if got := cacheGet(h, "/me", map[string]string{"Cookie": "session=a"}); got.Body.String() != a.Body.String() {
+		t.Fatalf("expected %q, got %q", a.Body, got.Body)
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	var calls int64
+	c := NewResponseCache(ResponseCacheConfig{TTL: time.Hour})
+	h := c.Decorate(countingHandler(&calls, map[string]string{HeaderCacheControl: "max-age=60, s-maxage=1"}))
+	cacheGet(h, "/", nil)
+	cacheGet(h, "/", nil)
+	if calls != 1 {
+		t.Fatalf("expected a hit, got %d calls", calls)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	cacheGet(h, "/", nil)
+	if calls != 2 {
+		t.Fatalf("s-maxage was not honored, got %d calls", calls)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	var calls int64
+	c := NewResponseCache(ResponseCacheConfig{MaxEntries: 2})
+	h := c.Decorate(countingHandler(&calls, nil))
+	cacheGet(h, "/a", nil)
+	cacheGet(h, "/b", nil)
+	cacheGet(h, "/a", nil) // /b is now least recently used
+	cacheGet(h, "/c", nil)
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+	for _, tt := range []struct {
+		path  string
+		cache string
+	}{
+		{"/a", "HIT"},
+		{"/c", "HIT"},
+		{"/b", "MISS"},
+	} {
+		if got := cacheGet(h, tt.path, nil).Header().Get(HeaderXCache); got != tt.cache {
+			t.Errorf("%s: expected %s, got %s", tt.path, tt.cache, got)
+		}
+	}
+
+	c = NewResponseCache(ResponseCacheConfig{MaxBytes: 25})
+	h = c.Decorate(countingHandler(&calls, nil))
+	for _, p := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		cacheGet(h, p, nil)
+		if c.Size() > 25 {
+			t.Fatalf("cache grew to %d bytes", c.Size())
+		}
+	}
+	if c.Len() == 0 || c.Len() == 5 {
+		t.Fatalf("expected some entries to be evicted, got %d", c.Len())
+	}
+}
+
+func TestResponseCacheInvalidate(t *testing.T) {
+	var calls int64
+	c := NewResponseCache(ResponseCacheConfig{})
+	h := c.Decorate(countingHandler(&calls, nil))
+	for _, p := range []string{"/items/1", "/items/2", "/orders/1"} {
+		cacheGet(h, p, nil)
+	}
+	c.Invalidate("/items")
+	if c.Len() != 1 {
+		t.Fatalf("expected 1 entry, got %d", c.Len())
+	}
+
+	m, _ := NewManager()
+	cancel := c.InvalidateOn(m)
+	defer cancel()
+	m.Signal("/orders")
+	if c.Len() != 0 {
+		t.Fatalf("expected the signal to invalidate /orders/1, got %d entries", c.Len())
+	}
+}
+
+func TestResponseCacheCoalescesMisses(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	c := NewResponseCache(ResponseCacheConfig{})
+	h := c.Decorate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		io.WriteString(w, "slow")
+	}))
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = cacheGet(h, "/slow", nil).Body.String()
+		}(i)
+	}
+	eventually(t, func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return len(c.inflight) == 1
+	})
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Fatalf("expected 1 handler call, got %d", n)
+	}
+	for i, b := range bodies {
+		if b != "slow" {
+			t.Fatalf("request %d got %q", i, b)
+		}
+	}
+}
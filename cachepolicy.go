@@ -0,0 +1,148 @@
+package mux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderExpires = "Expires"
+	HeaderPragma  = "Pragma"
+)
+
+// CachePolicy describes the Cache-Control of responses. max-age is always
+// sent unless NoStore is set.
+type CachePolicy struct {
+	Public         bool
+	Private        bool
+	NoCache        bool
+	NoStore        bool
+	MustRevalidate bool
+	Immutable      bool
+	MaxAge         time.Duration
+	// SMaxAge overrides MaxAge for shared caches.
+	SMaxAge              time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	// Expires also sets the Expires header for HTTP/1.0 caches.
+	Expires bool
+	// Vary lists the request headers the response depends on.
+	Vary []string
+	// Extensions are appended to the Cache-Control directives.
+	Extensions []string
+}
+
+var (
+	// CacheNoStore forbids storing responses at all.
+	CacheNoStore = CachePolicy{NoStore: true}
+	// CachePrivateRevalidate lets browsers store responses but revalidate them
+	// on every use.
+	CachePrivateRevalidate = CachePolicy{Private: true}
+)
+
+// String returns the Cache-Control header value.
+func (p CachePolicy) String() string {
+	ds := []string{}
+	if p.Public {
+		ds = append(ds, "public")
+	}
+	if p.Private {
+		ds = append(ds, "private")
+	}
+	if p.NoStore {
+		ds = append(ds, "no-store")
+	}
+	if p.NoCache {
+		ds = append(ds, "no-cache")
+	}
+	if p.MustRevalidate {
+		ds = append(ds, "must-revalidate")
+	}
+	if !p.NoStore {
+		ds = append(ds, fmt.Sprintf("max-age=%d", seconds(p.MaxAge)))
+		if p.SMaxAge > 0 {
+			ds = append(ds, fmt.Sprintf("s-maxage=%d", seconds(p.SMaxAge)))
+		}
+		if p.StaleWhileRevalidate > 0 {
+			ds = append(ds, fmt.Sprintf("stale-while-revalidate=%d", seconds(p.StaleWhileRevalidate)))
+		}
+		if p.StaleIfError > 0 {
+			ds = append(ds, fmt.Sprintf("stale-if-error=%d", seconds(p.StaleIfError)))
+		}
+		if p.Immutable {
+			ds = append(ds, "immutable")
+		}
+	}
+	ds = append(ds, p.Extensions...)
+	return strings.Join(ds, ", ")
+}
+
+// Apply sets the Cache-Control, Expires and Vary headers for a response
+// created at now.
+func (p CachePolicy) Apply(h http.Header, now time.Time) {
+	h.Set(HeaderCacheControl, p.String())
+	if p.Expires {
+		expires := now.Add(p.MaxAge)
+		if p.NoStore || p.NoCache || p.MaxAge <= 0 {
+			expires = time.Unix(0, 0)
+		}
+		h.Set(HeaderExpires, expires.UTC().Format(http.TimeFormat))
+	}
+	if len(p.Vary) > 0 {
+		AddVary(h, p.Vary...)
+	}
+}
+
+// CacheControl applies p to responses to GET and HEAD requests with a
+// cacheable status. Responses for which the handler set its own Cache-Control
+// header are left alone.
+func CacheControl(p CachePolicy) Decorator {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.serve(next, w, r)
+		})
+	}
+}
+
+// serve calls next, applying p just before the header is written.
+func (p CachePolicy) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		next.ServeHTTP(w, r)
+		return
+	}
+	apply := func(h http.Header, status int) {
+		if h.Get(HeaderCacheControl) == "" && cacheableStatus(status) {
+			p.Apply(h, time.Now())
+		}
+	}
+	rw := NewResponseWriter(w)
+	rw.BeforeWrite(func(rw *ResponseWriter) {
+		apply(rw.Header(), rw.Status())
+	})
+	next.ServeHTTP(rw.Expose(), r)
+	if !rw.Written() {
+		apply(w.Header(), http.StatusOK)
+	}
+}
+
+// cacheableStatus reports whether responses with status may be stored by
+// caches. 304 is included as it must repeat the Cache-Control of the 200 it
+// stands for.
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusPartialContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusNotModified,
+		http.StatusPermanentRedirect, http.StatusNotFound,
+		http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
@@ -0,0 +1,374 @@
+package mux
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	HeaderContentLength = "Content-Length"
+)
+
+const (
+	ContentEncodingDeflate  = "deflate"
+	ContentEncodingBrotli   = "br"
+	ContentEncodingZstd     = "zstd"
+	ContentEncodingIdentity = "identity"
+)
+
+// Encoder is a resettable compressing writer such as *gzip.Writer. Brotli and
+// zstd writers from third party packages satisfy it as well.
+type Encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Encoding names a content coding and creates its Encoders. A level of zero
+// selects the default level of the encoding.
+type Encoding struct {
+	Name string
+	New  func(w io.Writer, level int) (Encoder, error)
+}
+
+var (
+	EncodingGZIP = Encoding{
+		Name: ContentEncodingGZIP,
+		New: func(w io.Writer, level int) (Encoder, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+	}
+	// HTTP deflate is the zlib format (RFC 1950), not raw deflate.
+	EncodingDeflate = Encoding{
+		Name: ContentEncodingDeflate,
+		New: func(w io.Writer, level int) (Encoder, error) {
+			if level == 0 {
+				level = zlib.DefaultCompression
+			}
+			return zlib.NewWriterLevel(w, level)
+		},
+	}
+)
+
+var DefaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+	"+json",
+	"+xml",
+}
+
+type CompressConfig struct {
+	// Encodings in order of preference. Defaults to gzip and deflate.
+	Encodings []Encoding
+	Level     int
+	// MinSize is the smallest body that is compressed. Defaults to 1024 bytes,
+	// negative values compress every body.
+	MinSize int
+	// ContentTypes lists compressible media types. Entries ending in "/" match
+	// a prefix, entries starting with "+" match a structured syntax suffix.
+	// Defaults to DefaultCompressibleTypes.
+	ContentTypes []string
+}
+
+func Compress(c CompressConfig) Decorator {
+	cp := newCompressor(c)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			AddVary(w.Header(), HeaderAcceptEncoding)
+			enc := cp.negotiate(r.Header.Get(HeaderAcceptEncoding))
+			if enc == nil || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressResponseWriter{ResponseWriter: NewResponseWriter(w), c: cp, encoding: enc}
+			returned := false
+			defer func() {
+				if !returned {
+					// the handler panicked, leave the response to Recover
+					cw.abort()
+					return
+				}
+				cw.close()
+			}()
+			next.ServeHTTP(expose(cw, w), r)
+			returned = true
+		})
+	}
+}
+
+type compressor struct {
+	encodings    []Encoding
+	level        int
+	minSize      int
+	contentTypes []string
+	pools        map[string]*sync.Pool
+}
+
+func newCompressor(c CompressConfig) *compressor {
+	cp := &compressor{
+		encodings:    c.Encodings,
+		level:        c.Level,
+		minSize:      c.MinSize,
+		contentTypes: c.ContentTypes,
+		pools:        map[string]*sync.Pool{},
+	}
+	if cp.encodings == nil {
+		cp.encodings = []Encoding{EncodingGZIP, EncodingDeflate}
+	}
+	if cp.minSize == 0 {
+		cp.minSize = 1024
+	}
+	if cp.contentTypes == nil {
+		cp.contentTypes = DefaultCompressibleTypes
+	}
+	for _, e := range cp.encodings {
+		cp.pools[e.Name] = &sync.Pool{}
+	}
+	return cp
+}
+
+// negotiate selects the encoding with the highest quality in the
+// Accept-Encoding header, preferring configuration order on ties.
+func (cp *compressor) negotiate(acceptEncoding string) *Encoding {
+	if acceptEncoding == "" {
+		return nil
+	}
+	qs := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, q := parseQuality(part)
+		if name != "" {
+			qs[strings.ToLower(name)] = q
+		}
+	}
+	var best *Encoding
+	bestQ := 0.0
+	for i, e := range cp.encodings {
+		q, ok := qs[e.Name]
+		if !ok {
+			q, ok = qs["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = &cp.encodings[i], q
+		}
+	}
+	return best
+}
+
+func (cp *compressor) compressible(contentType string) bool {
+	mt := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, ct := range cp.contentTypes {
+		switch {
+		case strings.HasSuffix(ct, "/"):
+			if strings.HasPrefix(mt, ct) {
+				return true
+			}
+		case strings.HasPrefix(ct, "+"):
+			if strings.HasSuffix(mt, ct) {
+				return true
+			}
+		case mt == ct:
+			return true
+		}
+	}
+	return false
+}
+
+func (cp *compressor) encoder(e *Encoding, w io.Writer) (Encoder, error) {
+	if enc, ok := cp.pools[e.Name].Get().(Encoder); ok {
+		enc.Reset(w)
+		return enc, nil
+	}
+	return e.New(w, cp.level)
+}
+
+func (cp *compressor) release(e *Encoding, enc Encoder) {
+	enc.Reset(nil)
+	cp.pools[e.Name].Put(enc)
+}
+
+type compressResponseWriter struct {
+	*ResponseWriter
+	c           *compressor
+	encoding    *Encoding
+	encoder     Encoder
+	status      int
+	wroteHeader bool
+	committed   bool
+	buf         []byte
+}
+
+func (w *compressResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	if statusCode < 200 {
+		// informational responses are passed through immediately
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+	if !w.mayCompress() {
+		w.commit(false)
+	}
+}
+
+func (w *compressResponseWriter) Write(bs []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.committed {
+		if w.encoder != nil {
+			return w.encoder.Write(bs)
+		}
+		return w.ResponseWriter.Write(bs)
+	}
+	w.buf = append(w.buf, bs...)
+	if len(w.buf) >= w.c.minSize {
+		if err := w.commit(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(bs), nil
+}
+
+func (w *compressResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.committed {
+		// a flushing handler is streaming, compress regardless of size
+		w.commit(true)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(writerOnly{w}, r)
+}
+
+// mayCompress reports whether the response is eligible for compression
+// based on its status and headers.
+func (w *compressResponseWriter) mayCompress() bool {
+	switch w.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	h := w.Header()
+	if ce := h.Get(HeaderContentEncoding); ce != "" && ce != ContentEncodingIdentity {
+		return false
+	}
+	if cl, err := strconv.Atoi(h.Get(HeaderContentLength)); err == nil && cl < w.c.minSize {
+		return false
+	}
+	if ct := h.Get(HeaderContentType); ct != "" && !w.c.compressible(ct) {
+		return false
+	}
+	return true
+}
+
+// commit writes the header and the buffered body, compressing if eligible.
+func (w *compressResponseWriter) commit(compress bool) error {
+	w.committed = true
+	h := w.Header()
+	if len(w.buf) > 0 && h.Get(HeaderContentType) == "" {
+		// apply sniffing algorithm to the uncompressed body
+		h.Set(HeaderContentType, http.DetectContentType(w.buf))
+	}
+	if compress && w.mayCompress() {
+		enc, err := w.c.encoder(w.encoding, w.ResponseWriter)
+		if err == nil {
+			w.encoder = enc
+			h.Set(HeaderContentEncoding, w.encoding.Name)
+			h.Del(HeaderContentLength)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (w *compressResponseWriter) close() {
+	if !w.committed && w.wroteHeader {
+		w.commit(len(w.buf) >= w.c.minSize)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+		w.c.release(w.encoding, w.encoder)
+		w.encoder = nil
+	}
+}
+
+// abort drops the buffered body without writing anything and returns the
+// encoder to the pool.
+func (w *compressResponseWriter) abort() {
+	w.buf = nil
+	if w.encoder != nil {
+		w.c.release(w.encoding, w.encoder)
+		w.encoder = nil
+	}
+}
+
+// parseQuality splits a header list element like "gzip;q=0.8" into its value
+// and quality.
+func parseQuality(s string) (string, float64) {
+	parts := strings.Split(s, ";")
+	value := strings.TrimSpace(parts[0])
+	q := 1.0
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "q=") {
+			if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+				q = f
+			}
+		}
+	}
+	return value, q
+}
+
+// AddVary adds values to the Vary header unless they are already present.
+func AddVary(h http.Header, values ...string) {
+	present := map[string]bool{}
+	for _, v := range h[HeaderVary] {
+		for _, f := range strings.Split(v, ",") {
+			present[strings.ToLower(strings.TrimSpace(f))] = true
+		}
+	}
+	if present["*"] {
+		return
+	}
+	add := []string{}
+	for _, v := range values {
+		if !present[strings.ToLower(v)] {
+			present[strings.ToLower(v)] = true
+			add = append(add, v)
+		}
+	}
+	if len(add) > 0 {
+		h.Add(HeaderVary, strings.Join(add, ", "))
+	}
+}
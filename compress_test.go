@@ -0,0 +1,231 @@
+package mux
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressNegotiate(t *testing.T) {
+	cp := newCompressor(CompressConfig{})
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"GZIP", "gzip"},
+		{"gzip;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.5, deflate;q=0.8", "deflate"},
+		{"br", ""},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if e := cp.negotiate(tt.accept); e != nil {
+			got = e.Name
+		}
+		if got != tt.want {
+			t.Errorf("Accept-Encoding %q: expected %q, got %q", tt.accept, tt.want, got)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	cp := newCompressor(CompressConfig{})
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"text/html; charset=utf-8", true},
+		{"application/json", true},
+		{"application/problem+json", true},
+		{"application/atom+xml", true},
+		{"image/svg+xml", true},
+		{"image/png", false},
+		{"application/octet-stream", false},
+		{"application/jsonx", false},
+	}
+	for _, tt := range tests {
+		if got := cp.compressible(tt.contentType); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.contentType, tt.want, got)
+		}
+	}
+}
+
+func decodeBody(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+	var r io.Reader = body
+	var err error
+	switch encoding {
+	case ContentEncodingGZIP:
+		r, err = gzip.NewReader(body)
+	case ContentEncodingDeflate:
+		r, err = zlib.NewReader(body)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("compress me ", 200)
+	tests := []struct {
+		name     string
+		accept   string
+		method   string
+		status   int
+		header   map[string]string
+		body     string
+		encoding string
+	}{
+		{"gzip", "gzip", http.MethodGet, http.StatusOK, nil, large, "gzip"},
+		{"deflate", "deflate", http.MethodGet, http.StatusOK, nil, large, "deflate"},
+		{"not accepted", "", http.MethodGet, http.StatusOK, nil, large, ""},
+		{"small", "gzip", http.MethodGet, http.StatusOK, nil, "small", ""},
+		{"small content length", "gzip", http.MethodGet, http.StatusOK, map[string]string{HeaderContentLength: "5"}, "small", ""},
+		{"incompressible", "gzip", http.MethodGet, http.StatusOK, map[string]string{HeaderContentType: "image/png"}, large, ""},
+		{"already encoded", "gzip", http.MethodGet, http.StatusOK, map[string]string{HeaderContentEncoding: "br"}, large, "br"},
+		{"no content", "gzip", http.MethodGet, http.StatusNoContent, nil, "", ""},
+		{"partial content", "gzip", http.MethodGet, http.StatusPartialContent, nil, large, ""},
+		{"error", "gzip", http.MethodGet, http.StatusNotFound, nil, large, "gzip"},
+		{"head", "gzip", http.MethodHead, http.StatusOK, nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Compress(CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set(HeaderAcceptEncoding, tt.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, w.Code)
+			}
+			if got := w.Header().Get(HeaderContentEncoding); got != tt.encoding {
+				t.Fatalf("expected encoding %q, got %q", tt.encoding, got)
+			}
+			if got := w.Header().Get(HeaderVary); got != HeaderAcceptEncoding {
+				t.Errorf("expected Vary Accept-Encoding, got %q", got)
+			}
+			if tt.encoding == "br" {
+				return
+			}
+			if got := decodeBody(t, tt.encoding, w.Body); got != tt.body {
+				t.Errorf("unexpected body %q", got)
+			}
+			if tt.encoding != "" && w.Header().Get(HeaderContentLength) != "" {
+				t.Error("compressed response kept Content-Length")
+			}
+		})
+	}
+}
+
+func TestCompressSniffsUncompressedBody(t *testing.T) {
+	h := Compress(CompressConfig{MinSize: -1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<!DOCTYPE html><html></html>")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HeaderAcceptEncoding, "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get(HeaderContentType); got != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected Content-Type %q", got)
+	}
+	if got := decodeBody(t, w.Header().Get(HeaderContentEncoding), w.Body); got != "<!DOCTYPE html><html></html>" {
+		t.Fatalf("unexpected body %q", got)
+	}
+}
+
+func TestCompressFlushStreams(t *testing.T) {
+	flushed := make(chan struct{})
+	h := Compress(CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		<-flushed
+		io.WriteString(w, "second")
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set(HeaderAcceptEncoding, "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get(HeaderContentEncoding); got != "gzip" {
+		t.Fatalf("a flushed response must be compressed regardless of size, got %q", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, 5)
+	if _, err := io.ReadFull(zr, first); err != nil || string(first) != "first" {
+		t.Fatalf("expected the flushed part before the handler returned, got %q %v", first, err)
+	}
+	close(flushed)
+	rest, _ := ioutil.ReadAll(zr)
+	if string(rest) != "second" {
+		t.Fatalf("unexpected rest %q", rest)
+	}
+}
+
+func TestCompressHandlerPanics(t *testing.T) {
+	recoverer := RecoverWith(RecoverConfig{Logger: log.New(ioutil.Discard, "", 0)})
+	h := recoverer(Compress(CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "partial")
+		panic("boom")
+	})))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HeaderAcceptEncoding, "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "partial") {
+		t.Fatalf("partial body leaked: %q", w.Body)
+	}
+	if got := w.Header().Get(HeaderContentEncoding); got != "" {
+		t.Fatalf("error response claims encoding %q", got)
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	h := http.Header{}
+	AddVary(h, "Origin")
+	AddVary(h, "origin", "Accept-Encoding")
+	if got := strings.Join(h[HeaderVary], ", "); got != "Origin, Accept-Encoding" {
+		t.Fatalf("unexpected Vary %q", got)
+	}
+	h = http.Header{HeaderVary: {"*"}}
+	AddVary(h, "Origin")
+	if got := strings.Join(h[HeaderVary], ", "); got != "*" {
+		t.Fatalf("unexpected Vary %q", got)
+	}
+}
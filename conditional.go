@@ -0,0 +1,182 @@
+package mux
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderIfMatch           = "If-Match"
+	HeaderIfModifiedSince   = "If-Modified-Since"
+	HeaderIfUnmodifiedSince = "If-Unmodified-Since"
+	HeaderLastModified      = "Last-Modified"
+)
+
+var ErrPreconditionFailed = NewHTTPError(http.StatusPreconditionFailed, "precondition_failed", "precondition failed")
+
+type ConditionalConfig struct {
+	// ETag returns the current ETag of the requested resource, or "" if it
+	// does not exist. It is required to enforce If-Match on unsafe methods.
+	ETag func(r *http.Request) string
+	// LastModified returns the modification time of the requested resource,
+	// or the zero time if it is unknown.
+	LastModified func(r *http.Request) time.Time
+	// Hash is used to compute ETags of GET responses if neither ETag is set
+	// nor the handler sets one. It defaults to SHA-1.
+	Hash func() hash.Hash
+	// Weak marks computed ETags as weak.
+	Weak bool
+}
+
+// Conditional evaluates conditional requests. If-None-Match and
+// If-Modified-Since are answered with 304 Not Modified for GET and HEAD,
+// If-Match and If-Unmodified-Since are answered with 412 Precondition Failed
+// if they do not hold. Without ETag and LastModified funcs, GET responses are
+// buffered to compute an ETag unless the handler sets one before writing; HEAD
+// requests are served by rendering the GET response without its body.
+func Conditional(c ConditionalConfig) Decorator {
+	if c.Hash == nil {
+		c.Hash = sha1.New
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+			if c.ETag != nil || c.LastModified != nil {
+				var etag string
+				var modified time.Time
+				if c.ETag != nil {
+					etag = c.ETag(r)
+				}
+				if c.LastModified != nil {
+					modified = c.LastModified(r)
+				}
+				if !preconditionsHold(r, etag, modified) {
+					WriteError(w, r, ErrPreconditionFailed)
+					return
+				}
+				if safe {
+					setValidators(w.Header(), etag, modified)
+					if notModified(r, etag, modified) {
+						writeNotModified(w)
+						return
+					}
+				} else if r.Header.Get(HeaderIfNoneMatch) != "" && etagMatch(r.Header.Get(HeaderIfNoneMatch), etag) {
+					WriteError(w, r, ErrPreconditionFailed)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !safe {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rw := &bufferedResponseWriter{
+				ResponseWriter: NewResponseWriter(w),
+				buffer:         &bytes.Buffer{},
+			}
+			rw.commit = func(etag string) bool {
+				return notModified(r, etag, lastModified(rw.header))
+			}
+
+			head := r.Method == http.MethodHead
+			req := r
+			if head {
+				// render the GET body, so HEAD gets the same ETag
+				req = r.WithContext(r.Context())
+				req.Method = http.MethodGet
+			}
+
+			next.ServeHTTP(expose(rw, w), req)
+
+			if rw.Hijacked() || rw.streaming {
+				return
+			}
+			h := rw.restoreHeader(w)
+			if rw.statusCode == http.StatusOK {
+				etag := computeETag(c.Hash, c.Weak, rw.buffer.Bytes())
+				h.Set(HeaderEtag, etag)
+				if notModified(r, etag, lastModified(h)) {
+					writeNotModified(w)
+					return
+				}
+			}
+			if head {
+				if h.Get(HeaderContentLength) == "" {
+					h.Set(HeaderContentLength, strconv.Itoa(rw.buffer.Len()))
+				}
+				if _, ok := h[HeaderContentType]; !ok && rw.buffer.Len() > 0 {
+					h.Set(HeaderContentType, http.DetectContentType(rw.buffer.Bytes()))
+				}
+				w.WriteHeader(rw.statusCode)
+				return
+			}
+			w.WriteHeader(rw.statusCode)
+			io.Copy(w, rw.buffer)
+		})
+	}
+}
+
+// preconditionsHold evaluates If-Match and If-Unmodified-Since.
+func preconditionsHold(r *http.Request, etag string, modified time.Time) bool {
+	if im := r.Header.Get(HeaderIfMatch); im != "" {
+		return etagMatchStrong(im, etag)
+	}
+	if ius, err := http.ParseTime(r.Header.Get(HeaderIfUnmodifiedSince)); err == nil && !modified.IsZero() {
+		return !modified.Truncate(time.Second).After(ius)
+	}
+	return true
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get(HeaderIfNoneMatch); inm != "" {
+		return etagMatch(inm, etag)
+	}
+	if ims, err := http.ParseTime(r.Header.Get(HeaderIfModifiedSince)); err == nil && !modified.IsZero() {
+		return !modified.Truncate(time.Second).After(ims)
+	}
+	return false
+}
+
+// etagMatchStrong reports whether etag is listed in an If-Match header, using
+// the strong comparison.
+func etagMatchStrong(header string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, t := range parseETags(header) {
+		if t == "*" || (t == etag && !strings.HasPrefix(etag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func setValidators(h http.Header, etag string, modified time.Time) {
+	if etag != "" {
+		h.Set(HeaderEtag, etag)
+	}
+	if !modified.IsZero() {
+		h.Set(HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+func lastModified(h http.Header) time.Time {
+	t, _ := http.ParseTime(h.Get(HeaderLastModified))
+	return t
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del(HeaderContentType)
+	h.Del(HeaderContentLength)
+	w.WriteHeader(http.StatusNotModified)
+}
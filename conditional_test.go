@@ -0,0 +1,179 @@
+package mux
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPreconditions(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+	tests := []struct {
+		name        string
+		header      map[string]string
+		etag        string
+		hold        bool
+		notModified bool
+	}{
+		{"none", nil, `"a"`, true, false},
+		{"if-match", map[string]string{HeaderIfMatch: `"a"`}, `"a"`, true, false},
+		{"if-match list", map[string]string{HeaderIfMatch: `"b", "a"`}, `"a"`, true, false},
+		{"if-match other", map[string]string{HeaderIfMatch: `"b"`}, `"a"`, false, false},
+		{"if-match weak", map[string]string{HeaderIfMatch: `W/"a"`}, `W/"a"`, false, false},
+		{"if-match any", map[string]string{HeaderIfMatch: "*"}, `"a"`, true, false},
+		{"if-match any missing", map[string]string{HeaderIfMatch: "*"}, "", false, false},
+		{"if-unmodified-since", map[string]string{HeaderIfUnmodifiedSince: after}, `"a"`, true, false},
+		{"if-unmodified-since earlier", map[string]string{HeaderIfUnmodifiedSince: before}, `"a"`, false, false},
+		{"if-match wins", map[string]string{HeaderIfMatch: `"a"`, HeaderIfUnmodifiedSince: before}, `"a"`, true, false},
+		{"if-none-match", map[string]string{HeaderIfNoneMatch: `"a"`}, `"a"`, true, true},
+		{"if-none-match weak", map[string]string{HeaderIfNoneMatch: `W/"a"`}, `"a"`, true, true},
+		{"if-none-match any", map[string]string{HeaderIfNoneMatch: "*"}, `"a"`, true, true},
+		{"if-none-match other", map[string]string{HeaderIfNoneMatch: `"b"`}, `"a"`, true, false},
+		{"if-modified-since", map[string]string{HeaderIfModifiedSince: after}, `"a"`, true, true},
+		{"if-modified-since earlier", map[string]string{HeaderIfModifiedSince: before}, `"a"`, true, false},
+		{"if-none-match wins", map[string]string{HeaderIfNoneMatch: `"b"`, HeaderIfModifiedSince: after}, `"a"`, true, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		if got := preconditionsHold(r, tt.etag, modified); got != tt.hold {
+			t.Errorf("%s: expected preconditions to hold %v, got %v", tt.name, tt.hold, got)
+		}
+		if got := notModified(r, tt.etag, modified); got != tt.notModified {
+			t.Errorf("%s: expected not modified %v, got %v", tt.name, tt.notModified, got)
+		}
+	}
+}
+
+func TestConditionalWithValidators(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := ConditionalConfig{
+		ETag:         func(r *http.Request) string { return `"v2"` },
+		LastModified: func(r *http.Request) time.Time { return modified },
+	}
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		status int
+	}{
+		{"get", http.MethodGet, nil, http.StatusOK},
+		{"get cached", http.MethodGet, map[string]string{HeaderIfNoneMatch: `"v2"`}, http.StatusNotModified},
+		{"head cached", http.MethodHead, map[string]string{HeaderIfNoneMatch: `"v2"`}, http.StatusNotModified},
+		{"get stale", http.MethodGet, map[string]string{HeaderIfNoneMatch: `"v1"`}, http.StatusOK},
+		{"put current", http.MethodPut, map[string]string{HeaderIfMatch: `"v2"`}, http.StatusOK},
+		{"put lost update", http.MethodPut, map[string]string{HeaderIfMatch: `"v1"`}, http.StatusPreconditionFailed},
+		{"put create only", http.MethodPut, map[string]string{HeaderIfNoneMatch: "*"}, http.StatusPreconditionFailed},
+		{"delete modified", http.MethodDelete, map[string]string{HeaderIfUnmodifiedSince: modified.Add(-time.Second).Format(http.TimeFormat)}, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := Conditional(c)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				io.WriteString(w, "body")
+			}))
+			r := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, w.Code)
+			}
+			if called != (tt.status == http.StatusOK) {
+				t.Fatalf("handler called: %v", called)
+			}
+			safe := tt.method == http.MethodGet || tt.method == http.MethodHead
+			if got := w.Header().Get(HeaderEtag); safe && got != `"v2"` {
+				t.Errorf("expected ETag \"v2\", got %q", got)
+			}
+		})
+	}
+}
+
+func TestConditionalComputedETag(t *testing.T) {
+	status := http.StatusOK
+	h := Conditional(ConditionalConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			io.WriteString(w, "hello")
+		}
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	do := func(method string, ifNoneMatch string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set(HeaderIfNoneMatch, ifNoneMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	get := do(http.MethodGet, "")
+	etag := get.Header.Get(HeaderEtag)
+	if etag != `"5-aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"` {
+		t.Fatalf("unexpected ETag %q", etag)
+	}
+	head := do(http.MethodHead, "")
+	if got := head.Header.Get(HeaderEtag); got != etag {
+		t.Fatalf("HEAD ETag %q differs from GET ETag %q", got, etag)
+	}
+	if head.ContentLength != 5 {
+		t.Fatalf("expected HEAD Content-Length 5, got %d", head.ContentLength)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		if resp := do(method, etag); resp.StatusCode != http.StatusNotModified {
+			t.Fatalf("%s: expected 304, got %d", method, resp.StatusCode)
+		}
+		if resp := do(method, `"other"`); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", method, resp.StatusCode)
+		}
+	}
+
+	status = http.StatusNotFound
+	if resp := do(http.MethodGet, etag); resp.StatusCode != http.StatusNotFound || resp.Header.Get(HeaderEtag) != "" {
+		t.Fatalf("expected an untagged 404, got %d %q", resp.StatusCode, resp.Header.Get(HeaderEtag))
+	}
+}
+
+func TestConditionalHandlerETagStreams(t *testing.T) {
+	h := Conditional(ConditionalConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderEtag, `"mine"`)
+		io.WriteString(w, "body")
+	}))
+	for _, tt := range []struct {
+		ifNoneMatch string
+		status      int
+		body        string
+	}{
+		{"", http.StatusOK, "body"},
+		{`"mine"`, http.StatusNotModified, ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.ifNoneMatch != "" {
+			r.Header.Set(HeaderIfNoneMatch, tt.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("If-None-Match %q: expected %d %q, got %d %q", tt.ifNoneMatch, tt.status, tt.body, w.Code, w.Body)
+		}
+		if got := w.Header().Get(HeaderEtag); got != `"mine"` {
+			t.Errorf("handler ETag replaced by %q", got)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package mux
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey string
+
+const (
+	errorRendererKey contextKey = "error-renderer"
+	routeKey         contextKey = "route"
+	routeSlotKey     contextKey = "route-slot"
+)
+
+// CurrentRoute returns the Route the Router matched for r. Decorators that
+// wrap the Router itself see the matched Route once the Router has served r.
+func CurrentRoute(r *http.Request) *Route {
+	if route, ok := r.Context().Value(routeKey).(*Route); ok {
+		return route
+	}
+	if slot, ok := r.Context().Value(routeSlotKey).(*routeSlot); ok {
+		return slot.route
+	}
+	return nil
+}
+
+// routeSlot lets decorators placed in front of the Router learn which Route
+// was matched further down the chain.
+type routeSlot struct {
+	route *Route
+}
+
+func trackRoute(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(routeSlotKey).(*routeSlot); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeSlotKey, &routeSlot{}))
+}
+
+func withRoute(r *http.Request, route *Route) *http.Request {
+	if slot, ok := r.Context().Value(routeSlotKey).(*routeSlot); ok {
+		slot.route = route
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeKey, route))
+}
+
+func routePattern(r *http.Request) string {
+	if route := CurrentRoute(r); route != nil {
+		return route.FullPath()
+	}
+	return ""
+}
@@ -11,18 +11,8 @@ func SetAllCORSHeaders(w http.ResponseWriter, r *http.Request, ac AccessControl)
 	if origin == "" {
 		return
 	}
-	if ac.AllowOrigin != "" {
-		if ac.AllowOrigin == "*" {
-			if ac.AllowCredentials {
-				w.Header().Set(HeaderAccessControlAllowOrigin, origin)
-				w.Header().Add(HeaderVary, HeaderOrigin)
-			} else {
-				w.Header().Set(HeaderAccessControlAllowOrigin, ac.AllowOrigin)
-			}
-		} else {
-			w.Header().Set(HeaderAccessControlAllowOrigin, ac.AllowOrigin)
-			w.Header().Add(HeaderVary, HeaderOrigin)
-		}
+	if !setOriginHeaders(w, origin, ac) {
+		return
 	}
 	if len(ac.ExposeHeaders) > 0 {
 		expose := strings.Join(ac.ExposeHeaders, ", ")
@@ -31,9 +21,6 @@ func SetAllCORSHeaders(w http.ResponseWriter, r *http.Request, ac AccessControl)
 	if ac.MaxAge > 0 {
 		w.Header().Set(HeaderAccessControlMaxAge, fmt.Sprintf("%d", ac.MaxAge))
 	}
-	if ac.AllowCredentials {
-		w.Header().Set(HeaderAccessControlAllowCredentials, fmt.Sprintf("%t", ac.AllowCredentials))
-	}
 	if len(ac.AllowMethods) > 0 {
 		methods := strings.Join(ac.AllowMethods, ", ")
 		w.Header().Set(HeaderAccessControlAllowMethods, methods)
@@ -42,6 +29,9 @@ func SetAllCORSHeaders(w http.ResponseWriter, r *http.Request, ac AccessControl)
 		hs := strings.Join(ac.AllowHeaders, ", ")
 		w.Header().Set(HeaderAccessControlAllowHeaders, hs)
 	}
+	if ac.AllowPrivateNetwork && r.Header.Get(HeaderAccessControlRequestPrivateNetwork) == "true" {
+		w.Header().Set(HeaderAccessControlAllowPrivateNetwork, "true")
+	}
 }
 
 func CORS(ac AccessControl) Decorator {
@@ -57,43 +47,152 @@ func CORS(ac AccessControl) Decorator {
 			// Is the HTTP method an OPTIONS request?
 			if r.Method == http.MethodOptions {
 				// Is there an Access-Control-Request-Method header?
-				acrm := r.Header.Get(HeaderAccessControlRequestMethod)
-				if acrm != "" {
+				if r.Header.Get(HeaderAccessControlRequestMethod) != "" {
 					// Preflight Request
-					// TODO: check validity of acrm -> invalid error
-					// acrh := r.Header.Get(HeaderAccessControlRequestHeaders)
-					// TODO: check validity of acrh -> invalid error
-					SetAllCORSHeaders(w, r, ac)
-					w.WriteHeader(http.StatusNoContent)
+					preflight(w, r, ac)
 					return
 				}
 			}
 			// Actual Request
-			if len(ac.ExposeHeaders) > 0 {
-				expose := strings.Join(ac.ExposeHeaders, ", ")
-				w.Header().Set(HeaderAccessControlExposeHeaders, expose)
-			}
-			if ac.AllowOrigin != "" {
-				if ac.AllowOrigin == "*" {
-					if ac.AllowCredentials {
-						w.Header().Set(HeaderAccessControlAllowOrigin, origin)
-						w.Header().Add(HeaderVary, HeaderOrigin)
-					} else {
-						w.Header().Set(HeaderAccessControlAllowOrigin, ac.AllowOrigin)
-					}
-				} else {
-					w.Header().Set(HeaderAccessControlAllowOrigin, ac.AllowOrigin)
-					w.Header().Add(HeaderVary, HeaderOrigin)
-				}
-			}
-			if ac.AllowCredentials {
-				w.Header().Set(HeaderAccessControlAllowCredentials, fmt.Sprintf("%t", ac.AllowCredentials))
-			}
+			setActualCORSHeaders(w, origin, ac)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// preflight answers a preflight request, rejecting it with 403 if the
+// requested method or headers are not allowed.
+func preflight(w http.ResponseWriter, r *http.Request, ac AccessControl) {
+	AddVary(w.Header(), HeaderOrigin, HeaderAccessControlRequestMethod, HeaderAccessControlRequestHeaders)
+	if _, ok := ac.allowedOrigin(r.Header.Get(HeaderOrigin)); !ok {
+		WriteError(w, r, NewHTTPError(http.StatusForbidden, "cors_origin_not_allowed", "origin not allowed"))
+		return
+	}
+	acrm := r.Header.Get(HeaderAccessControlRequestMethod)
+	if !ac.allowsMethod(acrm) {
+		WriteError(w, r, NewHTTPError(http.StatusForbidden, "cors_method_not_allowed", fmt.Sprintf("method %s not allowed", acrm)))
+		return
+	}
+	for _, h := range splitList(r.Header.Get(HeaderAccessControlRequestHeaders)) {
+		if !ac.allowsHeader(h) {
+			WriteError(w, r, NewHTTPError(http.StatusForbidden, "cors_header_not_allowed", fmt.Sprintf("header %s not allowed", h)))
+			return
+		}
+	}
+	SetAllCORSHeaders(w, r, ac)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setActualCORSHeaders(w http.ResponseWriter, origin string, ac AccessControl) {
+	if !setOriginHeaders(w, origin, ac) {
+		return
+	}
+	if len(ac.ExposeHeaders) > 0 {
+		expose := strings.Join(ac.ExposeHeaders, ", ")
+		w.Header().Set(HeaderAccessControlExposeHeaders, expose)
+	}
+}
+
+// setOriginHeaders sets Access-Control-Allow-Origin and -Credentials and
+// reports whether the origin is allowed.
+func setOriginHeaders(w http.ResponseWriter, origin string, ac AccessControl) bool {
+	value, ok := ac.allowedOrigin(origin)
+	if value != "*" {
+		// the response differs by origin
+		AddVary(w.Header(), HeaderOrigin)
+	}
+	if !ok {
+		return false
+	}
+	w.Header().Set(HeaderAccessControlAllowOrigin, value)
+	if ac.AllowCredentials {
+		w.Header().Set(HeaderAccessControlAllowCredentials, fmt.Sprintf("%t", ac.AllowCredentials))
+	}
+	return true
+}
+
+// allowedOrigin returns the value for Access-Control-Allow-Origin. A wildcard
+// is answered with the request origin if credentials are allowed.
+func (ac AccessControl) allowedOrigin(origin string) (string, bool) {
+	origins := ac.AllowOrigins
+	if ac.AllowOrigin != "" {
+		origins = append([]string{ac.AllowOrigin}, origins...)
+	}
+	for _, o := range origins {
+		if o == "*" {
+			if ac.AllowCredentials {
+				return origin, true
+			}
+			return "*", true
+		}
+	}
+	for _, o := range origins {
+		if matchOrigin(o, origin) {
+			return origin, true
+		}
+	}
+	if ac.AllowOriginFunc != nil && ac.AllowOriginFunc(origin) {
+		return origin, true
+	}
+	return "", false
+}
+
+func (ac AccessControl) allowsMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+		// CORS-safelisted methods
+		return true
+	}
+	for _, m := range ac.AllowMethods {
+		if m == method || (m == "*" && !ac.AllowCredentials) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeader reports whether a header listed in a preflight is allowed.
+// Browsers list safelisted headers only if their value is not safelisted,
+// e.g. Content-Type: application/json, so they have to be allowed explicitly.
+func (ac AccessControl) allowsHeader(header string) bool {
+	for _, h := range ac.AllowHeaders {
+		if strings.EqualFold(h, header) || (h == "*" && !ac.AllowCredentials) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin matches origin against an exact origin or a pattern with a
+// single wildcard such as "https://*.example.com".
+func matchOrigin(pattern string, origin string) bool {
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	if len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	// the wildcard stands for subdomain labels only
+	return !strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:@")
+}
+
+func splitList(s string) []string {
+	vs := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
 var (
 	AccessControlDefaults  AccessControl
 	AccessControlPreflight AccessControl
@@ -112,25 +211,32 @@ func init() {
 }
 
 const (
-	HeaderOrigin                        = "Origin"
-	HeaderAccessControlAllowOrigin      = "Access-Control-Allow-Origin"
-	HeaderAccessControlExposeHeaders    = "Access-Control-Expose-Headers"
-	HeaderAccessControlMaxAge           = "Access-Control-Max-Age"
-	HeaderAccessControlAllowCredentials = "Access-Control-Allow-Credentials"
-	HeaderAccessControlAllowMethods     = "Access-Control-Allow-Methods"
-	HeaderAccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	HeaderOrigin                           = "Origin"
+	HeaderAccessControlAllowOrigin         = "Access-Control-Allow-Origin"
+	HeaderAccessControlExposeHeaders       = "Access-Control-Expose-Headers"
+	HeaderAccessControlMaxAge              = "Access-Control-Max-Age"
+	HeaderAccessControlAllowCredentials    = "Access-Control-Allow-Credentials"
+	HeaderAccessControlAllowMethods        = "Access-Control-Allow-Methods"
+	HeaderAccessControlAllowHeaders        = "Access-Control-Allow-Headers"
+	HeaderAccessControlAllowPrivateNetwork = "Access-Control-Allow-Private-Network"
 )
 
 const (
-	HeaderAccessControlRequestMethod  = "Access-Control-Request-Method"
-	HeaderAccessControlRequestHeaders = "Access-Control-Request-Headers"
+	HeaderAccessControlRequestMethod         = "Access-Control-Request-Method"
+	HeaderAccessControlRequestHeaders        = "Access-Control-Request-Headers"
+	HeaderAccessControlRequestPrivateNetwork = "Access-Control-Request-Private-Network"
 )
 
 type AccessControl struct {
-	AllowOrigin      string
-	ExposeHeaders    []string
-	MaxAge           uint64
-	AllowCredentials bool
-	AllowMethods     []string
-	AllowHeaders     []string
+	AllowOrigin string
+	// AllowOrigins lists allowed origins, "*" or patterns like "https://*.example.com".
+	AllowOrigins []string
+	// AllowOriginFunc is consulted for origins not matched by AllowOrigin(s).
+	AllowOriginFunc     func(origin string) bool
+	ExposeHeaders       []string
+	MaxAge              uint64
+	AllowCredentials    bool
+	AllowMethods        []string
+	AllowHeaders        []string
+	AllowPrivateNetwork bool
 }
@@ -0,0 +1,181 @@
+package mux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "HTTPS://Example.com", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com", "https://example.com:8443", false},
+		{"https://*.example.com", "https://api.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://.example.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "https://evil.com/.example.com", false},
+		{"https://*.example.com", "https://evil.com:1.example.com", false},
+		{"https://*.example.com", "https://user@x.example.com", false},
+		{"https://*.example.com", "http://api.example.com", false},
+		{"https://*.example.com:8443", "https://api.example.com:8443", true},
+	}
+	for _, tt := range tests {
+		if got := matchOrigin(tt.pattern, tt.origin); got != tt.want {
+			t.Errorf("matchOrigin(%q, %q): expected %v, got %v", tt.pattern, tt.origin, tt.want, got)
+		}
+	}
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		ac     AccessControl
+		origin string
+		value  string
+		ok     bool
+	}{
+		{"wildcard", AccessControl{AllowOrigin: "*"}, "https://a.example", "*", true},
+		{"wildcard with credentials", AccessControl{AllowOrigin: "*", AllowCredentials: true}, "https://a.example", "https://a.example", true},
+		{"listed", AccessControl{AllowOrigins: []string{"https://a.example"}}, "https://a.example", "https://a.example", true},
+		{"not listed", AccessControl{AllowOrigins: []string{"https://a.example"}}, "https://b.example", "", false},
+		{"pattern", AccessControl{AllowOrigins: []string{"https://*.example.com"}}, "https://x.example.com", "https://x.example.com", true},
+		{"func", AccessControl{AllowOriginFunc: func(o string) bool { return o == "https://f.example" }}, "https://f.example", "https://f.example", true},
+		{"func rejects", AccessControl{AllowOriginFunc: func(o string) bool { return false }}, "https://f.example", "", false},
+		{"nothing allowed", AccessControl{}, "https://a.example", "", false},
+	}
+	for _, tt := range tests {
+		value, ok := tt.ac.allowedOrigin(tt.origin)
+		if value != tt.value || ok != tt.ok {
+			t.Errorf("%s: expected (%q, %v), got (%q, %v)", tt.name, tt.value, tt.ok, value, ok)
+		}
+	}
+}
+
+func preflightRequest(target string, origin string, method string, headers string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, target, nil)
+	r.Header.Set(HeaderOrigin, origin)
+	r.Header.Set(HeaderAccessControlRequestMethod, method)
+	if headers != "" {
+		r.Header.Set(HeaderAccessControlRequestHeaders, headers)
+	}
+	return r
+}
+
+func TestCORSPreflight(t *testing.T) {
+	policy := AccessControl{
+		AllowOrigins: []string{"https://app.example.com"},
+		AllowMethods: []string{"PUT"},
+		AllowHeaders: []string{"X-Token"},
+		MaxAge:       60,
+	}
+	wildcard := AccessControl{AllowOrigin: "*", AllowMethods: []string{"*"}, AllowHeaders: []string{"*"}}
+	credentials := wildcard
+	credentials.AllowCredentials = true
+	tests := []struct {
+		name    string
+		ac      AccessControl
+		origin  string
+		method  string
+		headers string
+		status  int
+	}{
+		{"allowed", policy, "https://app.example.com", "PUT", "x-token", http.StatusNoContent},
+		{"safelisted method", policy, "https://app.example.com", "POST", "", http.StatusNoContent},
+		{"origin", policy, "https://evil.example", "PUT", "", http.StatusForbidden},
+		{"method", policy, "https://app.example.com", "DELETE", "", http.StatusForbidden},
+		{"header", policy, "https://app.example.com", "PUT", "X-Other", http.StatusForbidden},
+		{"safelisted header not listed", policy, "https://app.example.com", "PUT", "Content-Type", http.StatusForbidden},
+		{"wildcards", wildcard, "https://a.example", "DELETE", "X-Any", http.StatusNoContent},
+		{"wildcard method with credentials", credentials, "https://a.example", "DELETE", "", http.StatusForbidden},
+		{"wildcard header with credentials", credentials, "https://a.example", "GET", "X-Any", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Error("preflight reached the handler")
+			})
+			w := httptest.NewRecorder()
+			CORS(tt.ac)(next).ServeHTTP(w, preflightRequest("/", tt.origin, tt.method, tt.headers))
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if got := w.Header().Get(HeaderVary); got != "Origin, Access-Control-Request-Method, Access-Control-Request-Headers" {
+				t.Errorf("unexpected Vary %q", got)
+			}
+			if w.Code != http.StatusNoContent {
+				if got := w.Header().Get(HeaderAccessControlAllowOrigin); got != "" {
+					t.Errorf("rejected preflight allows origin %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSPreflightHeaders(t *testing.T) {
+	ac := AccessControl{
+		AllowOrigin:         "*",
+		AllowCredentials:    true,
+		AllowMethods:        []string{"PUT"},
+		AllowHeaders:        []string{"X-Token"},
+		MaxAge:              60,
+		AllowPrivateNetwork: true,
+	}
+	r := preflightRequest("/", "https://a.example", "PUT", "X-Token")
+	r.Header.Set(HeaderAccessControlRequestPrivateNetwork, "true")
+	w := httptest.NewRecorder()
+	CORS(ac)(http.NotFoundHandler()).ServeHTTP(w, r)
+	want := map[string]string{
+		HeaderAccessControlAllowOrigin:         "https://a.example",
+		HeaderAccessControlAllowCredentials:    "true",
+		HeaderAccessControlAllowMethods:        "PUT",
+		HeaderAccessControlAllowHeaders:        "X-Token",
+		HeaderAccessControlMaxAge:              "60",
+		HeaderAccessControlAllowPrivateNetwork: "true",
+	}
+	for k, v := range want {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("%s: expected %q, got %q", k, v, got)
+		}
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	ac := AccessControl{AllowOrigins: []string{"https://app.example.com"}, ExposeHeaders: []string{"Location"}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	tests := []struct {
+		origin string
+		allow  string
+		expose string
+	}{
+		{"https://app.example.com", "https://app.example.com", "Location"},
+		{"https://evil.example", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set(HeaderOrigin, tt.origin)
+		w := httptest.NewRecorder()
+		CORS(ac)(next).ServeHTTP(w, r)
+		if w.Code != http.StatusCreated {
+			t.Errorf("%s: expected the handler to run, got %d", tt.origin, w.Code)
+		}
+		if got := w.Header().Get(HeaderAccessControlAllowOrigin); got != tt.allow {
+			t.Errorf("%s: expected allow origin %q, got %q", tt.origin, tt.allow, got)
+		}
+		if got := w.Header().Get(HeaderAccessControlExposeHeaders); got != tt.expose {
+			t.Errorf("%s: expected expose headers %q, got %q", tt.origin, tt.expose, got)
+		}
+		if got := w.Header().Get(HeaderVary); got != HeaderOrigin {
+			t.Errorf("%s: expected Vary Origin, got %q", tt.origin, got)
+		}
+	}
+}
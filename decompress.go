@@ -0,0 +1,139 @@
+package mux
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrBodyTooLarge = NewHTTPError(http.StatusRequestEntityTooLarge, "body_too_large", "decompressed request body too large")
+)
+
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	DecoderGZIP Decoder = func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}
+	// DecoderDeflate accepts the zlib format and falls back to raw deflate
+	// streams as sent by some clients.
+	DecoderDeflate Decoder = func(r io.Reader) (io.ReadCloser, error) {
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && (uint(header[0])<<8|uint(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+)
+
+type DecompressConfig struct {
+	// MaxSize limits the decompressed body. Defaults to 10 MiB.
+	MaxSize int64
+	// Decoders by content coding. Defaults to gzip and deflate.
+	Decoders map[string]Decoder
+}
+
+func Decompress(c DecompressConfig) Decorator {
+	maxSize := c.MaxSize
+	if maxSize <= 0 {
+		maxSize = 10 << 20
+	}
+	decoders := c.Decoders
+	if decoders == nil {
+		decoders = map[string]Decoder{
+			ContentEncodingGZIP:    DecoderGZIP,
+			ContentEncodingDeflate: DecoderDeflate,
+		}
+	}
+	supported := []string{}
+	for name := range decoders {
+		supported = append(supported, name)
+	}
+	sort.Strings(supported)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			codings := contentCodings(r.Header.Get(HeaderContentEncoding))
+			if len(codings) == 0 || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, coding := range codings {
+				if _, ok := decoders[coding]; !ok {
+					w.Header().Set(HeaderAcceptEncoding, strings.Join(supported, ", "))
+					WriteError(w, r, NewHTTPError(http.StatusUnsupportedMediaType, "unsupported_content_encoding", fmt.Sprintf("unsupported content encoding %q", coding)))
+					return
+				}
+			}
+			body := r.Body
+			var closers []io.Closer
+			var reader io.Reader = body
+			// codings are listed in the order they were applied
+			for i := len(codings) - 1; i >= 0; i-- {
+				dr, err := decoders[codings[i]](reader)
+				if err != nil {
+					WriteError(w, r, NewHTTPError(http.StatusBadRequest, "invalid_content_encoding", fmt.Sprintf("invalid %s body", codings[i])).Wrap(err))
+					return
+				}
+				closers = append(closers, dr)
+				reader = dr
+			}
+			r.Body = &decompressedBody{r: reader, remaining: maxSize, closers: append(closers, body)}
+			r.Header.Del(HeaderContentEncoding)
+			r.Header.Del(HeaderContentLength)
+			r.ContentLength = -1
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func contentCodings(header string) []string {
+	codings := []string{}
+	for _, c := range strings.Split(header, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c != "" && c != ContentEncodingIdentity {
+			codings = append(codings, c)
+		}
+	}
+	return codings
+}
+
+type decompressedBody struct {
+	r         io.Reader
+	remaining int64
+	closers   []io.Closer
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// probe for data beyond the limit
+		var one [1]byte
+		if n, _ := io.ReadFull(b.r, one[:]); n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *decompressedBody) Close() error {
+	var err error
+	for _, c := range b.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
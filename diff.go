@@ -0,0 +1,202 @@
+package mux
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type ChangeKind uint8
+
+const (
+	ChangeAddedPath ChangeKind = iota
+	ChangeRemovedPath
+	ChangeAddedMethod
+	ChangeRemovedMethod
+	ChangeRenamedParameter
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAddedPath:
+		return "AddedPath"
+	case ChangeRemovedPath:
+		return "RemovedPath"
+	case ChangeAddedMethod:
+		return "AddedMethod"
+	case ChangeRemovedMethod:
+		return "RemovedMethod"
+	case ChangeRenamedParameter:
+		return "RenamedParameter"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaking reports whether a change of this kind can break existing clients.
+// Parameter renames do not change the wire format and are not breaking.
+func (k ChangeKind) Breaking() bool {
+	return k == ChangeRemovedPath || k == ChangeRemovedMethod
+}
+
+// Additive reports whether a change of this kind only adds to the API.
+func (k ChangeKind) Additive() bool {
+	return k == ChangeAddedPath || k == ChangeAddedMethod
+}
+
+type Change struct {
+	Kind    ChangeKind
+	Path    string
+	Methods []string
+	From    string
+	To      string
+}
+
+func (c Change) Breaking() bool {
+	return c.Kind.Breaking()
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeRenamedParameter:
+		return fmt.Sprintf("%s %s: %s -> %s", c.Kind, c.Path, c.From, c.To)
+	default:
+		return fmt.Sprintf("%s %s %v", c.Kind, c.Path, c.Methods)
+	}
+}
+
+type Changes []Change
+
+func (cs Changes) Filter(f func(c Change) bool) Changes {
+	fcs := Changes{}
+	for _, c := range cs {
+		if f(c) {
+			fcs = append(fcs, c)
+		}
+	}
+	return fcs
+}
+
+func (cs Changes) Breaking() Changes {
+	return cs.Filter(func(c Change) bool { return c.Kind.Breaking() })
+}
+
+func (cs Changes) Additive() Changes {
+	return cs.Filter(func(c Change) bool { return c.Kind.Additive() })
+}
+
+func (cs Changes) IsCompatible() bool {
+	return len(cs.Breaking()) == 0
+}
+
+func (cs Changes) String() string {
+	var lines []string
+	for _, c := range cs {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func DiffRouters(old *Router, updated *Router) Changes {
+	return DiffRoutes(Describe(old.Root()), Describe(updated.Root()))
+}
+
+// DiffRoutes compares two route dumps. Paths are matched with parameter and
+// catch-all names ignored, so renaming :id to :userID is reported as a
+// parameter rename rather than a removed and an added path.
+func DiffRoutes(old *RouteInfo, updated *RouteInfo) Changes {
+	olds := endpoints(old)
+	news := endpoints(updated)
+
+	keys := []string{}
+	for k := range olds {
+		keys = append(keys, k)
+	}
+	for k := range news {
+		if _, ok := olds[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	cs := Changes{}
+	for _, k := range keys {
+		o, inOld := olds[k]
+		n, inNew := news[k]
+		switch {
+		case !inNew:
+			cs = append(cs, Change{Kind: ChangeRemovedPath, Path: o.path, Methods: o.methods})
+		case !inOld:
+			cs = append(cs, Change{Kind: ChangeAddedPath, Path: n.path, Methods: n.methods})
+		default:
+			if o.path != n.path {
+				cs = append(cs, Change{Kind: ChangeRenamedParameter, Path: n.path, From: o.path, To: n.path})
+			}
+			if removed := difference(o.methods, n.methods); len(removed) > 0 {
+				cs = append(cs, Change{Kind: ChangeRemovedMethod, Path: n.path, Methods: removed})
+			}
+			if added := difference(n.methods, o.methods); len(added) > 0 {
+				cs = append(cs, Change{Kind: ChangeAddedMethod, Path: n.path, Methods: added})
+			}
+		}
+	}
+	return cs
+}
+
+type endpoint struct {
+	path    string
+	methods []string
+}
+
+func endpoints(info *RouteInfo) map[string]endpoint {
+	eps := map[string]endpoint{}
+	var walk func(*RouteInfo)
+	walk = func(i *RouteInfo) {
+		if i == nil {
+			return
+		}
+		if len(i.Methods) > 0 {
+			ms := append([]string{}, i.Methods...)
+			sort.Strings(ms)
+			eps[normalizePath(i.FullPath)] = endpoint{path: i.FullPath, methods: ms}
+		}
+		for _, c := range i.Children {
+			walk(c)
+		}
+	}
+	walk(info)
+	return eps
+}
+
+func normalizePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if len(s) == 0 {
+			continue
+		}
+		switch ClassifyKind(s) {
+		case KindParameter:
+			segments[i] = ":"
+		case KindCatchAll:
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func difference(a []string, b []string) []string {
+	d := []string{}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			d = append(d, x)
+		}
+	}
+	return d
+}
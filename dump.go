@@ -0,0 +1,271 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	redacted = "[REDACTED]"
+)
+
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+type DumpConfig struct {
+	// Output receives the dumps. Defaults to os.Stdout.
+	Output io.Writer
+	// Logger, if set, receives the dumps as fields instead of Output.
+	Logger FieldLogger
+	// Responses enables dumping of responses.
+	Responses bool
+	// RedactHeaders are replaced in dumps. DefaultRedactHeaders is used if nil.
+	RedactHeaders []string
+	// RedactFields are JSON object keys whose values are replaced in dumped bodies.
+	RedactFields []string
+	// MaxBody caps the dumped body size. Defaults to 64 KiB, negative values omit bodies.
+	MaxBody int
+	// SampleRate is the fraction of requests to dump. Zero dumps all requests.
+	SampleRate float64
+	// Routes restricts dumping to the given route patterns, e.g. "/users/:id".
+	Routes []string
+	// Filter decides after the handler returned whether to dump a request.
+	Filter func(r *http.Request, status int) bool
+}
+
+func Dump(c DumpConfig) Decorator {
+	out := c.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	redactHeaders := c.RedactHeaders
+	if redactHeaders == nil {
+		redactHeaders = DefaultRedactHeaders
+	}
+	maxBody := c.MaxBody
+	if maxBody == 0 {
+		maxBody = 64 * 1024
+	}
+	if maxBody < 0 {
+		maxBody = 0
+	}
+	redactor := newJSONRedactor(c.RedactFields)
+	var mutex sync.Mutex
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.SampleRate > 0 && rand.Float64() >= c.SampleRate {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = trackRoute(r)
+
+			var reqBody []byte
+			var reqSize int64 = -1
+			if maxBody > 0 && r.Body != nil && r.Body != http.NoBody {
+				prefix, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(maxBody)+1))
+				if err != nil {
+					WriteError(w, r, NewHTTPError(http.StatusBadRequest, "", "unable to read request body").Wrap(err))
+					return
+				}
+				reqBody = prefix
+				reqSize = r.ContentLength
+				r.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(prefix), r.Body), Closer: r.Body}
+			}
+
+			rw := &dumpResponseWriter{ResponseWriter: NewResponseWriter(w), max: maxBody}
+			next.ServeHTTP(expose(rw, w), r)
+
+			if len(c.Routes) > 0 && !containsString(c.Routes, routePattern(r)) {
+				return
+			}
+			if c.Filter != nil && !c.Filter(r, status(rw.ResponseWriter)) {
+				return
+			}
+
+			var buf bytes.Buffer
+			fmt.Fprintf(&buf, "%s %s %s\n", r.Method, r.URL.RequestURI(), r.Proto)
+			fmt.Fprintf(&buf, "Host: %s\n", r.Host)
+			writeDumpHeaders(&buf, r.Header, redactHeaders)
+			writeDumpBody(&buf, reqBody, reqSize, maxBody, r.Header, redactor)
+			reqDump := buf.String()
+
+			respDump := ""
+			if c.Responses {
+				buf.Reset()
+				fmt.Fprintf(&buf, "%s %d %s\n", r.Proto, status(rw.ResponseWriter), http.StatusText(status(rw.ResponseWriter)))
+				writeDumpHeaders(&buf, w.Header(), redactHeaders)
+				writeDumpBody(&buf, rw.body.Bytes(), rw.Size(), maxBody, w.Header(), redactor)
+				respDump = buf.String()
+			}
+
+			id := requestID(w, r)
+			if c.Logger != nil {
+				args := []interface{}{"request_id", id, "request", reqDump}
+				if c.Responses {
+					args = append(args, "response", respDump)
+				}
+				c.Logger.Info("dump", args...)
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if id != "" {
+				fmt.Fprintf(out, "[%s]\n", id)
+			}
+			fmt.Fprintf(out, "%s\n", reqDump)
+			if c.Responses {
+				fmt.Fprintf(out, "%s\n", respDump)
+			}
+		})
+	}
+}
+
+func writeDumpHeaders(buf *bytes.Buffer, h http.Header, redact []string) {
+	keys := []string{}
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			if containsFold(redact, k) {
+				v = redacted
+			}
+			fmt.Fprintf(buf, "%s: %s\n", k, v)
+		}
+	}
+}
+
+func writeDumpBody(buf *bytes.Buffer, body []byte, size int64, max int, h http.Header, redactor *jsonRedactor) {
+	if len(body) == 0 || max == 0 {
+		return
+	}
+	truncated := len(body) > max
+	if truncated {
+		body = body[:max]
+	}
+	if isJSON(h.Get(HeaderContentType)) {
+		body = redactor.redact(body, truncated)
+	}
+	buf.WriteString("\n")
+	buf.Write(body)
+	if truncated {
+		if size > 0 {
+			fmt.Fprintf(buf, "\n... (truncated, %d bytes total)", size)
+		} else {
+			buf.WriteString("\n... (truncated)")
+		}
+	}
+	buf.WriteString("\n")
+}
+
+type jsonRedactor struct {
+	fields  []string
+	pattern *regexp.Regexp
+}
+
+func newJSONRedactor(fields []string) *jsonRedactor {
+	jr := &jsonRedactor{fields: fields}
+	if len(fields) > 0 {
+		quoted := []string{}
+		for _, f := range fields {
+			quoted = append(quoted, regexp.QuoteMeta(f))
+		}
+		jr.pattern = regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+	}
+	return jr
+}
+
+// redact replaces the values of the configured fields. Complete documents are
+// rewritten structurally, truncated ones are redacted textually.
+func (jr *jsonRedactor) redact(body []byte, truncated bool) []byte {
+	if len(jr.fields) == 0 {
+		return body
+	}
+	if !truncated {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			if bs, err := json.Marshal(jr.walk(v)); err == nil {
+				return bs
+			}
+		}
+	}
+	return jr.pattern.ReplaceAll(body, []byte(`${1}"`+redacted+`"`))
+}
+
+func (jr *jsonRedactor) walk(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			if containsFold(jr.fields, k) {
+				x[k] = redacted
+			} else {
+				x[k] = jr.walk(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = jr.walk(e)
+		}
+	}
+	return v
+}
+
+type dumpResponseWriter struct {
+	*ResponseWriter
+	body bytes.Buffer
+	max  int
+}
+
+func (w *dumpResponseWriter) Write(bs []byte) (int, error) {
+	// one byte more than max is kept to detect truncation
+	if room := w.max + 1 - w.body.Len(); w.max > 0 && room > 0 {
+		if room > len(bs) {
+			room = len(bs)
+		}
+		w.body.Write(bs[:room])
+	}
+	return w.ResponseWriter.Write(bs)
+}
+
+func (w *dumpResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(writerOnly{w}, r)
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func isJSON(contentType string) bool {
+	mt := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	return mt == ContentTypeJSON || mt == ContentTypeProblemJSON || strings.HasSuffix(mt, "+json")
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(ss []string, s string) bool {
+	for _, x := range ss {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
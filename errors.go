@@ -0,0 +1,166 @@
+package mux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	ContentTypeProblemJSON = "application/problem+json"
+)
+
+var (
+	ErrNotFound = NewHTTPError(http.StatusNotFound, "not_found", "")
+)
+
+// HTTPError is an error that is rendered as an RFC 7807 problem document.
+type HTTPError struct {
+	Status int
+	Code   string
+	Title  string
+	Detail string
+	Type   string
+	Extra  map[string]interface{}
+	Err    error
+}
+
+func NewHTTPError(status int, code string, detail string) *HTTPError {
+	return &HTTPError{
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%d %s", e.StatusCode(), e.title())
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *HTTPError) StatusCode() int {
+	if e.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return e.Status
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// With returns a copy of e with an additional member, leaving e untouched so
+// that shared errors like ErrNotFound can be extended per request.
+func (e *HTTPError) With(key string, value interface{}) *HTTPError {
+	c := e.copy()
+	c.Extra[key] = value
+	return c
+}
+
+// Wrap returns a copy of e wrapping err.
+func (e *HTTPError) Wrap(err error) *HTTPError {
+	c := e.copy()
+	c.Err = err
+	return c
+}
+
+func (e *HTTPError) copy() *HTTPError {
+	c := *e
+	c.Extra = map[string]interface{}{}
+	for k, v := range e.Extra {
+		c.Extra[k] = v
+	}
+	return &c
+}
+
+func (e *HTTPError) title() string {
+	if e.Title != "" {
+		return e.Title
+	}
+	return http.StatusText(e.StatusCode())
+}
+
+func (e *HTTPError) MarshalJSON() ([]byte, error) {
+	doc := map[string]interface{}{}
+	for k, v := range e.Extra {
+		doc[k] = v
+	}
+	typ := e.Type
+	if typ == "" {
+		typ = "about:blank"
+	}
+	doc["type"] = typ
+	doc["title"] = e.title()
+	doc["status"] = e.StatusCode()
+	if e.Detail != "" {
+		doc["detail"] = e.Detail
+	}
+	if e.Code != "" {
+		doc["code"] = e.Code
+	}
+	return json.Marshal(doc)
+}
+
+// AsHTTPError converts err into an HTTPError. Errors that do not carry a status
+// code are reported as 500 without exposing their message.
+func AsHTTPError(err error) *HTTPError {
+	switch e := err.(type) {
+	case *HTTPError:
+		return e
+	case *BindError:
+		return NewHTTPError(http.StatusBadRequest, "invalid_request", e.Error()).With("errors", e.Errors).Wrap(e)
+	case interface{ StatusCode() int }:
+		return NewHTTPError(e.StatusCode(), "", err.Error()).Wrap(err)
+	default:
+		return NewHTTPError(http.StatusInternalServerError, "", "").Wrap(err)
+	}
+}
+
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, err error)
+
+// RenderProblem writes err as an application/problem+json document.
+func RenderProblem(w http.ResponseWriter, r *http.Request, err error) {
+	he := AsHTTPError(err)
+	bs, jerr := json.Marshal(he)
+	if jerr != nil {
+		bs, _ = json.Marshal(NewHTTPError(http.StatusInternalServerError, "", ""))
+	}
+	w.Header().Set(HeaderContentType, ContentTypeProblemJSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(he.StatusCode())
+	if r.Method != http.MethodHead {
+		w.Write(bs)
+	}
+}
+
+// WriteError renders err with the ErrorRenderer of the Router serving r, or
+// with RenderProblem if there is none.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	if render, ok := r.Context().Value(errorRendererKey).(ErrorRenderer); ok && render != nil {
+		render(w, r, err)
+		return
+	}
+	RenderProblem(w, r, err)
+}
+
+type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+func (f ErrorHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f(w, r); err != nil {
+		WriteError(w, r, err)
+	}
+}
+
+func withErrorRenderer(r *http.Request, render ErrorRenderer) *http.Request {
+	if render == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), errorRendererKey, render))
+}
@@ -8,6 +8,10 @@ import (
 func LogRequests(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		h.ServeHTTP(w, r)
+		if id := requestID(w, r); id != "" {
+			log.Printf("%s requested %s %s [%s]", r.RemoteAddr, r.Method, r.URL, id)
+			return
+		}
 		log.Printf("%s requested %s %s", r.RemoteAddr, r.Method, r.URL)
 	})
 }
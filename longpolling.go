@@ -2,13 +2,16 @@ package mux
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,19 +25,232 @@ func NewManager(options ...func(*Manager) error) (*Manager, error) {
 	managerID++
 	m := &Manager{
 		id:           managerID,
+		origin:       newRequestID(),
 		signals:      map[string]Signal{},
 		lastSignals:  map[string]time.Time{},
 		sessionEtags: map[string]map[string]string{},
+		sessionSeen:  map[string]time.Time{},
+		session:      SessionAuthSubject,
+		sessionTTL:   10 * time.Minute,
+		key:          PathKey,
+		prefixes:     true,
+		timeout:      30 * time.Second,
+		maxTimeout:   2 * time.Minute,
+		listeners:    map[int]func(string){},
+		events:       map[string][]Event{},
+		replay:       16,
+		heartbeat:    15 * time.Second,
+		waiters:      map[string]int{},
+		done:         make(chan struct{}),
+		released:     ShutdownUnavailable,
+		hash:         sha1.New,
 	}
+	SignalTransport(NewMemoryTransport())(m)
 	return m, m.SetOption(options...)
 }
 
 type Manager struct {
+	// parked is accessed atomically and must stay the first field to be
+	// 64-bit aligned on 32-bit platforms.
+	parked       int64
 	id           int
 	mutex        sync.RWMutex
 	signals      map[string]Signal
 	lastSignals  map[string]time.Time
 	sessionEtags map[string]map[string]string
+	sessionSeen  map[string]time.Time
+	lastSweep    time.Time
+	session      func(r *http.Request) string
+	sessionTTL   time.Duration
+	key          func(r *http.Request) string
+	topics       func(key string) []string
+	prefixes     bool
+	timeout      time.Duration
+	maxTimeout   time.Duration
+	listeners    map[int]func(string)
+	nextListener int
+	events       map[string][]Event
+	eventID      uint64
+	replay       int
+	heartbeat    time.Duration
+	origin       string
+	transport    Transport
+	unsubscribe  func()
+	onError      func(error)
+	waiters      map[string]int
+	done         chan struct{}
+	closeOnce    sync.Once
+	released     http.Handler
+	version      func(r *http.Request) string
+	hash         func() hash.Hash
+	weak         bool
+}
+
+// KeyFunc sets how the resource key of a request is derived. Keys identify
+// the resource a client polls and are matched against signals.
+func KeyFunc(f func(r *http.Request) string) func(*Manager) error {
+	return func(m *Manager) error {
+		if f == nil {
+			return fmt.Errorf("key func must not be nil")
+		}
+		m.key = f
+		return nil
+	}
+}
+
+// Topics maps a resource key to the topics it listens to. A signal wakes every
+// key that lists it as a topic.
+func Topics(f func(key string) []string) func(*Manager) error {
+	return func(m *Manager) error {
+		m.topics = f
+		return nil
+	}
+}
+
+// PrefixSignals controls whether a signal also wakes every key it is a prefix
+// of. It is enabled by default.
+func PrefixSignals(enabled bool) func(*Manager) error {
+	return func(m *Manager) error {
+		m.prefixes = enabled
+		return nil
+	}
+}
+
+// DefaultTimeout is used if the Long-Poll header does not contain a valid
+// number of seconds.
+func DefaultTimeout(d time.Duration) func(*Manager) error {
+	return func(m *Manager) error {
+		if d <= 0 {
+			return fmt.Errorf("default timeout must be positive")
+		}
+		m.timeout = d
+		return nil
+	}
+}
+
+// MaxTimeout caps the timeout requested in the Long-Poll header.
+func MaxTimeout(d time.Duration) func(*Manager) error {
+	return func(m *Manager) error {
+		if d <= 0 {
+			return fmt.Errorf("max timeout must be positive")
+		}
+		m.maxTimeout = d
+		return nil
+	}
+}
+
+func PathKey(r *http.Request) string {
+	return r.URL.Path
+}
+
+// PathQueryKey includes the query, so that /items?page=1 and /items?page=2
+// are tracked separately.
+func PathQueryKey(r *http.Request) string {
+	if q := r.URL.Query().Encode(); q != "" {
+		return r.URL.Path + "?" + q
+	}
+	return r.URL.Path
+}
+
+// VaryKey extends the key derived by base with the values of the given
+// request headers, e.g. VaryKey(PathKey, "Accept").
+func VaryKey(base func(r *http.Request) string, headers ...string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		key := base(r)
+		for _, h := range headers {
+			key += "|" + h + "=" + r.Header.Get(h)
+		}
+		return key
+	}
+}
+
+// matches reports whether a signal concerns key.
+func (m *Manager) matches(key string, signal string) bool {
+	if m.prefixes && strings.HasPrefix(key, signal) {
+		return true
+	}
+	if m.topics != nil {
+		for _, t := range m.topics(key) {
+			if t == signal {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *Manager) longPollTimeout(header string) time.Duration {
+	timeout := m.timeout
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		timeout = time.Duration(secs) * time.Second
+	}
+	if timeout > m.maxTimeout {
+		timeout = m.maxTimeout
+	}
+	return timeout
+}
+
+// SessionFunc sets how the session of a request is identified. ETags are
+// tracked per session; requests without a session ("") share one.
+func SessionFunc(f func(r *http.Request) string) func(*Manager) error {
+	return func(m *Manager) error {
+		if f == nil {
+			return fmt.Errorf("session func must not be nil")
+		}
+		m.session = f
+		return nil
+	}
+}
+
+// SessionCookie identifies sessions by the value of the named cookie.
+func SessionCookie(name string) func(*Manager) error {
+	return SessionFunc(func(r *http.Request) string {
+		if c, err := r.Cookie(name); err == nil && c.Value != "" {
+			return "cookie:" + c.Value
+		}
+		return SessionAuthSubject(r)
+	})
+}
+
+// SessionHeader identifies sessions by the value of the named header.
+func SessionHeader(name string) func(*Manager) error {
+	return SessionFunc(func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return "header:" + v
+		}
+		return SessionAuthSubject(r)
+	})
+}
+
+// SessionTTL sets how long the state of an inactive session is kept.
+func SessionTTL(ttl time.Duration) func(*Manager) error {
+	return func(m *Manager) error {
+		if ttl <= 0 {
+			return fmt.Errorf("session ttl must be positive")
+		}
+		m.sessionTTL = ttl
+		return nil
+	}
+}
+
+// SessionAuthSubject identifies sessions by the basic auth user or a digest
+// of the Authorization header. Anonymous requests have no session and share
+// their ETags.
+func SessionAuthSubject(r *http.Request) string {
+	if u, _, ok := r.BasicAuth(); ok {
+		return "user:" + u
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return fmt.Sprintf("auth:%x", sha1.Sum([]byte(auth)))
+	}
+	return ""
+}
+
+// SessionRemoteIP identifies sessions by the client IP. Only use it if
+// clients connect directly, as clients behind a proxy or load balancer share
+// their remote address.
+func SessionRemoteIP(r *http.Request) string {
+	return "ip:" + remoteIP(r)
 }
 
 func (m *Manager) SetOption(options ...func(*Manager) error) error {
@@ -46,13 +262,101 @@ func (m *Manager) SetOption(options ...func(*Manager) error) error {
 	return nil
 }
 
+// TransportErrors sets a function called with errors publishing signals.
+func TransportErrors(f func(err error)) func(*Manager) error {
+	return func(m *Manager) error {
+		m.onError = f
+		return nil
+	}
+}
+
+// Signal wakes every key concerned by prefix, here and on the Managers
+// connected by the transport.
 func (m *Manager) Signal(prefix string) {
+	m.signal(prefix)
+	m.notify(prefix)
+	m.publish(MessageSignal, prefix)
+}
+
+// Invalidate drops the ETags recorded for every key concerned by prefix
+// without waking waiters, so that the next request is answered in full.
+func (m *Manager) Invalidate(prefix string) {
+	m.mutex.Lock()
+	m.invalidate(prefix)
+	m.mutex.Unlock()
+	m.publish(MessageInvalidate, prefix)
+}
+
+func (m *Manager) publish(kind string, key string) {
+	err := m.transport.Publish(TransportMessage{Kind: kind, Key: key, Origin: m.origin})
+	if err != nil && m.onError != nil {
+		m.onError(err)
+	}
+}
+
+// receive applies messages published by other Managers.
+func (m *Manager) receive(msg TransportMessage) {
+	if msg.Origin == m.origin {
+		return
+	}
+	switch msg.Kind {
+	case MessageSignal:
+		m.signal(msg.Key)
+		m.notify(msg.Key)
+	case MessageInvalidate:
+		m.mutex.Lock()
+		m.invalidate(msg.Key)
+		m.mutex.Unlock()
+	}
+}
+
+// Listen registers f to be called with every signal. The returned function
+// removes the listener.
+func (m *Manager) Listen(f func(signal string)) (cancel func()) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.nextListener++
+	id := m.nextListener
+	m.listeners[id] = f
+	return func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.listeners, id)
+	}
+}
+
+func (m *Manager) notify(signal string) {
+	m.mutex.RLock()
+	fs := make([]func(string), 0, len(m.listeners))
+	for _, f := range m.listeners {
+		fs = append(fs, f)
+	}
+	m.mutex.RUnlock()
+	for _, f := range fs {
+		f(signal)
+	}
+}
+
+func (m *Manager) signal(prefix string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	m.invalidate(prefix)
+
+	for key, signal := range m.signals {
+		if m.matches(key, prefix) {
+			close(signal)
+			delete(m.signals, key)
+		}
+	}
+}
+
+// invalidate drops the ETags of keys concerned by prefix. The caller must
+// hold the lock.
+func (m *Manager) invalidate(prefix string) {
 	for id, _ := range m.sessionEtags {
 		for key, _ := range m.sessionEtags[id] {
-			if strings.HasPrefix(key, prefix) {
+			if m.matches(key, prefix) {
 				delete(m.sessionEtags[id], key)
 				if len(m.sessionEtags[id]) == 0 {
 					delete(m.sessionEtags, id)
@@ -61,15 +365,102 @@ func (m *Manager) Signal(prefix string) {
 		}
 	}
 	m.lastSignals[prefix] = time.Now()
+}
 
-	for key, signal := range m.signals {
-		if strings.HasPrefix(key, prefix) {
-			close(signal)
+// park registers a waiter for key. The returned function must be called when
+// the waiter is done, so that the signal of a key nobody waits for is dropped.
+func (m *Manager) park(key string) (Signal, func()) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, exists := m.signals[key]
+	if !exists {
+		s = make(Signal)
+		m.signals[key] = s
+	}
+	m.waiters[key]++
+	atomic.AddInt64(&m.parked, 1)
+	return s, func() {
+		atomic.AddInt64(&m.parked, -1)
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.waiters[key]--
+		if m.waiters[key] > 0 {
+			return
+		}
+		delete(m.waiters, key)
+		if m.signals[key] == s {
 			delete(m.signals, key)
 		}
 	}
 }
 
+// Parked returns the number of long-poll requests currently waiting for a
+// signal.
+func (m *Manager) Parked() int64 {
+	return atomic.LoadInt64(&m.parked)
+}
+
+// ShutdownResponse sets the handler answering long-poll requests released by
+// Close. It defaults to ShutdownUnavailable.
+func ShutdownResponse(h http.Handler) func(*Manager) error {
+	return func(m *Manager) error {
+		if h == nil {
+			return fmt.Errorf("shutdown response must not be nil")
+		}
+		m.released = h
+		return nil
+	}
+}
+
+// ShutdownUnavailable answers with 503 Service Unavailable, asking the client
+// to retry right away, which lets it reach another instance.
+var ShutdownUnavailable = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HeaderRetryAfter, "0")
+	WriteError(w, r, NewHTTPError(http.StatusServiceUnavailable, "shutting_down", "server is shutting down"))
+})
+
+// Close releases every waiting long-poll request and event stream and
+// disconnects from the transport. Requests arriving later are served without
+// waiting.
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.mutex.Lock()
+		unsubscribe := m.unsubscribe
+		m.unsubscribe = nil
+		m.mutex.Unlock()
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+	})
+	return nil
+}
+
+// Shutdown closes the Manager and waits until all parked requests have been
+// answered or ctx is done.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.Close()
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for m.Parked() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+func (m *Manager) closed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
 func (m *Manager) Await(key string) Signal {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -85,7 +476,7 @@ func (m *Manager) recordEtag(requestTime time.Time, id string, key string, value
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	for prefix, last := range m.lastSignals {
-		if strings.HasPrefix(key, prefix) && last.After(requestTime) {
+		if last.After(requestTime) && m.matches(key, prefix) {
 			// do not record an old tag
 			return
 		}
@@ -94,102 +485,346 @@ func (m *Manager) recordEtag(requestTime time.Time, id string, key string, value
 		m.sessionEtags[id] = map[string]string{}
 	}
 	m.sessionEtags[id][key] = value
+	m.sessionSeen[id] = time.Now()
+	m.sweep()
+}
+
+// sweep drops the state of sessions inactive for longer than the session TTL
+// and signal times too old to matter. The caller must hold the lock.
+func (m *Manager) sweep() {
+	now := time.Now()
+	if now.Sub(m.lastSweep) < m.sessionTTL/2 {
+		return
+	}
+	m.lastSweep = now
+	for id, seen := range m.sessionSeen {
+		if now.Sub(seen) > m.sessionTTL {
+			delete(m.sessionSeen, id)
+			delete(m.sessionEtags, id)
+		}
+	}
+	for prefix, last := range m.lastSignals {
+		if now.Sub(last) > m.sessionTTL {
+			delete(m.lastSignals, prefix)
+		}
+	}
+	for key, es := range m.events {
+		if len(es) == 0 || now.Sub(es[len(es)-1].Time) > m.sessionTTL {
+			delete(m.events, key)
+		}
+	}
 }
 
 func (m *Manager) etag(sid string, key string) string {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	etags, ok := m.sessionEtags[sid]
 	if !ok {
 		return ""
 	}
+	m.sessionSeen[sid] = time.Now()
 	etag, _ := etags[key]
 	return etag
 }
 
-func extractKey(r *http.Request) string {
-	return r.URL.Path
-}
-
 const (
 	HeaderEtag        = "Etag"
 	HeaderLongPoll    = "Long-Poll"
 	HeaderIfNoneMatch = "If-None-Match"
+	HeaderRetryAfter  = "Retry-After"
 )
 
-func LongPolling() (middleware Decorator, signal SignalFunc) {
-
+// LongPolling creates a Manager and returns its decorator and signal func.
+// Options that fail are skipped; use NewManager to check them.
+func LongPolling(options ...func(*Manager) error) (middleware Decorator, signal SignalFunc) {
 	manager, _ := NewManager()
+	for _, opt := range options {
+		manager.SetOption(opt)
+	}
+	return manager.LongPolling, manager.Signal
+}
 
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			sid := "default"
-			key := extractKey(r)
-			longPoll := r.Header.Get(HeaderLongPoll)
-			ifNoneMatch := r.Header.Get(HeaderIfNoneMatch)
-			if longPoll != "" {
-				if ifNoneMatch != "" {
-					if etag := manager.etag(sid, key); etag == ifNoneMatch {
-						timeout, _ := strconv.Atoi(longPoll)
-						if timeout < 1 {
-							timeout = 1
-						}
-						select {
-						case <-time.After(time.Duration(timeout) * time.Second):
-							w.Header().Add(HeaderEtag, ifNoneMatch)
-							w.WriteHeader(http.StatusNotModified)
-							return
-						case <-manager.Await(key):
-						}
-					}
+func (m *Manager) LongPolling(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sid := m.session(r)
+		key := m.key(r)
+		longPoll := r.Header.Get(HeaderLongPoll)
+		ifNoneMatch := r.Header.Get(HeaderIfNoneMatch)
+		if longPoll != "" && ifNoneMatch != "" && !m.closed() {
+			etag := m.etag(sid, key)
+			if m.version != nil {
+				etag = m.versionETag(r)
+			}
+			if etag != "" && etagMatch(ifNoneMatch, etag) {
+				signal, release := m.park(key)
+				timer := time.NewTimer(m.longPollTimeout(longPoll))
+				select {
+				case <-timer.C:
+					release()
+					w.Header().Set(HeaderEtag, etag)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				case <-r.Context().Done():
+					// the client is gone
+					timer.Stop()
+					release()
+					return
+				case <-m.done:
+					timer.Stop()
+					m.released.ServeHTTP(w, r)
+					release()
+					return
+				case <-signal:
+					timer.Stop()
+					release()
 				}
 			}
+		}
 
-			rw := &responseWriter{
-				ResponseWriter: w,
-				buffer:         &bytes.Buffer{},
-				statusCode:     http.StatusOK,
+		requestTime := time.Now()
+
+		if m.version != nil {
+			// the ETag is known up front, so the body can be streamed
+			etag := m.versionETag(r)
+			if etagMatch(ifNoneMatch, etag) {
+				m.recordEtag(requestTime, sid, key, etag)
+				w.Header().Set(HeaderEtag, etag)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			// only successful responses carry the tag
+			ok := func(h http.Header) {
+				m.recordEtag(requestTime, sid, key, etag)
+				h.Set(HeaderEtag, etag)
+			}
+			rw := NewResponseWriter(w)
+			rw.BeforeWrite(func(rw *ResponseWriter) {
+				if rw.Status() == http.StatusOK {
+					ok(rw.Header())
+				}
+			})
+			next.ServeHTTP(rw.Expose(), r)
+			if !rw.Written() {
+				ok(w.Header())
 			}
+			return
+		}
 
-			requestTime := time.Now()
+		rw := &bufferedResponseWriter{
+			ResponseWriter: NewResponseWriter(w),
+			buffer:         &bytes.Buffer{},
+			commit: func(etag string) bool {
+				m.recordEtag(requestTime, sid, key, etag)
+				return etagMatch(ifNoneMatch, etag)
+			},
+		}
 
-			next.ServeHTTP(rw, r)
+		next.ServeHTTP(expose(rw, w), r)
 
-			switch rw.statusCode {
-			case http.StatusOK:
-				etag := rw.Header().Get(HeaderEtag)
-				if etag == "" {
-					// only calculate an etag if none was generated yet
-					etag = fmt.Sprintf(`"%d-%x"`, rw.buffer.Len(), sha1.Sum(rw.buffer.Bytes()))
-					w.Header().Add(HeaderEtag, etag)
-				}
-				manager.recordEtag(requestTime, sid, key, etag)
-				if ifNoneMatch == etag {
-					w.WriteHeader(http.StatusNotModified)
-					return
-				}
-				w.WriteHeader(http.StatusOK)
-				io.Copy(w, rw.buffer)
+		if rw.Hijacked() || rw.streaming {
+			return
+		}
+		h := rw.restoreHeader(w)
+
+		switch rw.statusCode {
+		case http.StatusOK:
+			etag := m.generateETag(rw.buffer.Bytes())
+			h.Set(HeaderEtag, etag)
+			if rw.commit(etag) {
+				h.Del(HeaderContentLength)
+				w.WriteHeader(http.StatusNotModified)
 				return
+			}
+			w.WriteHeader(http.StatusOK)
+			io.Copy(w, rw.buffer)
+			return
+		default:
+			w.WriteHeader(rw.statusCode)
+			io.Copy(w, rw.buffer)
+			return
+		}
+	})
+}
+
+// VersionFunc sets a function returning the current version or ETag of the
+// resource requested by r. The ETag is then known before the handler runs, so
+// the response is streamed instead of buffered to compute a digest.
+func VersionFunc(f func(r *http.Request) string) func(*Manager) error {
+	return func(m *Manager) error {
+		m.version = f
+		return nil
+	}
+}
+
+// ETagHash sets the hash used to compute ETags of buffered responses. It
+// defaults to SHA-1.
+func ETagHash(f func() hash.Hash) func(*Manager) error {
+	return func(m *Manager) error {
+		if f == nil {
+			return fmt.Errorf("hash must not be nil")
+		}
+		m.hash = f
+		return nil
+	}
+}
+
+// WeakETags marks computed ETags as weak, e.g. if responses are compressed
+// or otherwise transformed on the way to the client.
+func WeakETags(enabled bool) func(*Manager) error {
+	return func(m *Manager) error {
+		m.weak = enabled
+		return nil
+	}
+}
+
+func (m *Manager) generateETag(body []byte) string {
+	return computeETag(m.hash, m.weak, body)
+}
+
+func computeETag(f func() hash.Hash, weak bool, body []byte) string {
+	h := f()
+	h.Write(body)
+	etag := fmt.Sprintf(`"%d-%x"`, len(body), h.Sum(nil))
+	if weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// versionETag quotes the version of the resource unless it is an ETag.
+func (m *Manager) versionETag(r *http.Request) string {
+	v := m.version(r)
+	if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, `W/"`) {
+		return v
+	}
+	etag := strconv.Quote(v)
+	if m.weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// etagMatch reports whether etag is listed in an If-None-Match header, using
+// the weak comparison.
+func etagMatch(header string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, t := range parseETags(header) {
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseETags splits a list of entity tags, respecting commas within quotes.
+func parseETags(header string) []string {
+	tags := []string{}
+	quoted := false
+	start := 0
+	for i := 0; i <= len(header); i++ {
+		if i < len(header) {
+			switch header[i] {
+			case '"':
+				quoted = !quoted
+				continue
+			case ',':
+				if quoted {
+					continue
+				}
 			default:
-				w.WriteHeader(rw.statusCode)
-				io.Copy(w, rw.buffer)
-				return
+				continue
 			}
-		})
-	}, manager.Signal
+		}
+		if t := strings.TrimSpace(header[start:i]); t != "" {
+			tags = append(tags, t)
+		}
+		start = i + 1
+	}
+	return tags
 }
 
-type responseWriter struct {
-	http.ResponseWriter
+// bufferedResponseWriter holds back the response until the handler returned,
+// unless the handler set an ETag before writing the header, in which case the
+// body is streamed. The header is snapshotted when it is written. Hijacking
+// and pushing are passed through.
+type bufferedResponseWriter struct {
+	*ResponseWriter
 	statusCode int
+	header     http.Header
 	buffer     *bytes.Buffer
+	streaming  bool
+	skip       bool
+	commit     func(etag string) bool
+}
+
+func (w *bufferedResponseWriter) snapshot(statusCode int) {
+	w.statusCode = statusCode
+	w.header = cloneHeader(w.Header())
 }
 
-func (w *responseWriter) Write(bs []byte) (int, error) {
+// restoreHeader resets the header of under to the snapshot taken when the
+// header was written, as headers set afterwards are ignored without buffering
+// too.
+func (w *bufferedResponseWriter) restoreHeader(under http.ResponseWriter) http.Header {
+	if w.header == nil {
+		w.snapshot(http.StatusOK)
+	}
+	h := under.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	for k, v := range w.header {
+		h[k] = v
+	}
+	return h
+}
+
+func (w *bufferedResponseWriter) Write(bs []byte) (int, error) {
+	if w.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.skip {
+		return len(bs), nil
+	}
+	if w.streaming {
+		return w.ResponseWriter.Write(bs)
+	}
 	return w.buffer.Write(bs)
 }
 
-func (w *responseWriter) WriteHeader(statusCode int) {
-	w.statusCode = statusCode
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if w.header != nil || w.Hijacked() {
+		return
+	}
+	w.snapshot(statusCode)
+	etag := w.header.Get(HeaderEtag)
+	if statusCode != http.StatusOK || etag == "" {
+		return
+	}
+	w.streaming = true
+	if w.commit(etag) {
+		w.skip = true
+		w.Header().Del(HeaderContentLength)
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+}
+
+func (w *bufferedResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.streaming {
+		return io.Copy(writerOnly{w}, r)
+	}
+	return w.buffer.ReadFrom(r)
+}
+
+func (w *bufferedResponseWriter) Flush() {
+	if w.streaming && !w.skip {
+		w.ResponseWriter.Flush()
+	}
 }
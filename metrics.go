@@ -0,0 +1,292 @@
+package mux
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ContentTypePrometheus = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	DefaultSizeBuckets     = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// NewMetrics creates RED metrics for HTTP requests labeled by method, route
+// pattern and status class. Use Decorate to instrument handlers and serve the
+// Metrics itself to expose them in the Prometheus text format.
+func NewMetrics(options ...func(*Metrics) error) (*Metrics, error) {
+	m := &Metrics{
+		namespace:       "http",
+		durationBuckets: DefaultDurationBuckets,
+		sizeBuckets:     DefaultSizeBuckets,
+		series:          map[metricLabels]*metricSeries{},
+	}
+	return m, m.SetOption(options...)
+}
+
+func MetricsNamespace(ns string) func(*Metrics) error {
+	return func(m *Metrics) error {
+		m.namespace = ns
+		return nil
+	}
+}
+
+func MetricsDurationBuckets(bs ...float64) func(*Metrics) error {
+	return func(m *Metrics) error {
+		if !sort.Float64sAreSorted(bs) {
+			return fmt.Errorf("buckets must be sorted")
+		}
+		m.durationBuckets = bs
+		return nil
+	}
+}
+
+func MetricsSizeBuckets(bs ...float64) func(*Metrics) error {
+	return func(m *Metrics) error {
+		if !sort.Float64sAreSorted(bs) {
+			return fmt.Errorf("buckets must be sorted")
+		}
+		m.sizeBuckets = bs
+		return nil
+	}
+}
+
+// MetricsGauge adds a gauge whose value is read from f whenever the metrics
+// are exposed, e.g. the number of parked long-poll requests of a Manager.
+func MetricsGauge(name string, help string, f func() float64) func(*Metrics) error {
+	return func(m *Metrics) error {
+		if name == "" || f == nil {
+			return fmt.Errorf("gauge needs a name and a value func")
+		}
+		m.gauges = append(m.gauges, metricGauge{name: name, help: help, value: f})
+		return nil
+	}
+}
+
+type Metrics struct {
+	// inFlight is accessed atomically and must stay the first field to be
+	// 64-bit aligned on 32-bit platforms.
+	inFlight        int64
+	namespace       string
+	durationBuckets []float64
+	sizeBuckets     []float64
+	mutex           sync.Mutex
+	series          map[metricLabels]*metricSeries
+	gauges          []metricGauge
+}
+
+type metricGauge struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+type metricLabels struct {
+	method string
+	route  string
+	status string
+}
+
+type metricSeries struct {
+	requests uint64
+	errors   uint64
+	duration *histogram
+	size     *histogram
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (m *Metrics) SetOption(options ...func(*Metrics) error) error {
+	for _, opt := range options {
+		if err := opt(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Metrics) Decorate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		atomic.AddInt64(&m.inFlight, 1)
+		defer atomic.AddInt64(&m.inFlight, -1)
+		r = trackRoute(r)
+		rw := NewResponseWriter(w)
+
+		next.ServeHTTP(rw.Expose(), r)
+
+		route := routePattern(r)
+		if route == "" {
+			// keep unmatched paths from creating a series each
+			route = "unmatched"
+		}
+		m.observe(metricLabels{method: metricMethod(r.Method), route: route, status: statusClass(status(rw))}, time.Since(start), rw.Size())
+	})
+}
+
+func (m *Metrics) observe(l metricLabels, d time.Duration, size int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.series[l]
+	if !ok {
+		s = &metricSeries{
+			duration: newHistogram(m.durationBuckets),
+			size:     newHistogram(m.sizeBuckets),
+		}
+		m.series[l] = s
+	}
+	s.requests++
+	if l.status == "5xx" {
+		s.errors++
+	}
+	s.duration.observe(d.Seconds())
+	s.size.observe(float64(size))
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HeaderContentType, ContentTypePrometheus)
+	w.Write(m.Bytes())
+}
+
+// Bytes renders the metrics in the Prometheus text exposition format.
+func (m *Metrics) Bytes() []byte {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ls := []metricLabels{}
+	for l := range m.series {
+		ls = append(ls, l)
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		if ls[i].route != ls[j].route {
+			return ls[i].route < ls[j].route
+		}
+		if ls[i].method != ls[j].method {
+			return ls[i].method < ls[j].method
+		}
+		return ls[i].status < ls[j].status
+	})
+
+	var buf bytes.Buffer
+	name := func(n string) string {
+		if m.namespace == "" {
+			return n
+		}
+		return m.namespace + "_" + n
+	}
+
+	n := name("requests_total")
+	writeMetricHeader(&buf, n, "counter", "Total number of HTTP requests.")
+	for _, l := range ls {
+		fmt.Fprintf(&buf, "%s%s %d\n", n, l.format(), m.series[l].requests)
+	}
+
+	n = name("request_errors_total")
+	writeMetricHeader(&buf, n, "counter", "Total number of HTTP requests answered with a 5xx status.")
+	for _, l := range ls {
+		fmt.Fprintf(&buf, "%s%s %d\n", n, l.format(), m.series[l].errors)
+	}
+
+	n = name("request_duration_seconds")
+	writeMetricHeader(&buf, n, "histogram", "HTTP request latency in seconds.")
+	for _, l := range ls {
+		writeHistogram(&buf, n, l, m.series[l].duration)
+	}
+
+	n = name("response_size_bytes")
+	writeMetricHeader(&buf, n, "histogram", "HTTP response body size in bytes.")
+	for _, l := range ls {
+		writeHistogram(&buf, n, l, m.series[l].size)
+	}
+
+	n = name("requests_in_flight")
+	writeMetricHeader(&buf, n, "gauge", "Number of HTTP requests currently being served.")
+	fmt.Fprintf(&buf, "%s %d\n", n, atomic.LoadInt64(&m.inFlight))
+
+	for _, g := range m.gauges {
+		n = name(g.name)
+		writeMetricHeader(&buf, n, "gauge", g.help)
+		fmt.Fprintf(&buf, "%s %s\n", n, formatFloat(g.value()))
+	}
+
+	return buf.Bytes()
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name string, typ string, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, typ)
+}
+
+func writeHistogram(buf *bytes.Buffer, name string, l metricLabels, h *histogram) {
+	for i, b := range h.buckets {
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", name, l.format("le", formatFloat(b)), h.counts[i])
+	}
+	fmt.Fprintf(buf, "%s_bucket%s %d\n", name, l.format("le", "+Inf"), h.count)
+	fmt.Fprintf(buf, "%s_sum%s %s\n", name, l.format(), formatFloat(h.sum))
+	fmt.Fprintf(buf, "%s_count%s %d\n", name, l.format(), h.count)
+}
+
+func (l metricLabels) format(extra ...string) string {
+	pairs := []string{
+		"method", l.method,
+		"route", l.route,
+		"status", l.status,
+	}
+	pairs = append(pairs, extra...)
+	parts := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escapeLabel(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func metricMethod(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return m
+	default:
+		return "OTHER"
+	}
+}
+
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
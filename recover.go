@@ -0,0 +1,70 @@
+package mux
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+type RecoverConfig struct {
+	// Logger receives the panic value and stack. The standard logger is used if nil.
+	Logger *log.Logger
+	// Error is rendered with WriteError. A plain 500 is rendered if nil.
+	Error error
+	// OnPanic is called with the recovered value and stack, e.g. to forward
+	// the panic to an error tracker.
+	OnPanic func(r *http.Request, v interface{}, stack []byte)
+}
+
+func Recover(h http.Handler) http.Handler {
+	return RecoverWith(RecoverConfig{})(h)
+}
+
+func RecoverWith(c RecoverConfig) Decorator {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := NewResponseWriter(w)
+			// headers set by the handler before it panicked do not apply to
+			// the error response
+			header := cloneHeader(w.Header())
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					// let net/http abort the response silently
+					panic(v)
+				}
+				stack := debug.Stack()
+				msg := fmt.Sprintf("panic serving %s %s: %v\n%s", r.Method, r.URL, v, stack)
+				if c.Logger != nil {
+					c.Logger.Print(msg)
+				} else {
+					log.Print(msg)
+				}
+				if c.OnPanic != nil {
+					c.OnPanic(r, v, stack)
+				}
+				if rw.Written() {
+					// too late to send an error response
+					return
+				}
+				err := c.Error
+				if err == nil {
+					err = NewHTTPError(http.StatusInternalServerError, "", "")
+				}
+				h := w.Header()
+				for k := range h {
+					delete(h, k)
+				}
+				for k, vs := range header {
+					h[k] = vs
+				}
+				WriteError(w, r, err)
+			}()
+			next.ServeHTTP(rw.Expose(), r)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package mux
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	HeaderTraceparent = "Traceparent"
+)
+
+const (
+	requestIDKey contextKey = "request-id"
+)
+
+// RequestID assigns an ID to every request. An incoming X-Request-ID header
+// is reused, otherwise the trace ID of a W3C traceparent header, otherwise a
+// random ID is generated. The ID is echoed in the X-Request-ID response header.
+func RequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := incomingRequestID(r)
+		if id == "" {
+			id = newRequestID()
+		}
+		// the response header makes the ID visible to decorators placed in
+		// front of this one
+		w.Header().Set(HeaderRequestID, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// requestID returns the ID assigned by RequestID. Decorators in front of
+// RequestID find it in the response header; without the decorator the
+// X-Request-ID request header is used.
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		return id
+	}
+	if id := w.Header().Get(HeaderRequestID); id != "" {
+		return id
+	}
+	return r.Header.Get(HeaderRequestID)
+}
+
+func incomingRequestID(r *http.Request) string {
+	if id := r.Header.Get(HeaderRequestID); validRequestID(id) {
+		return id
+	}
+	// version-traceid-parentid-flags
+	parts := strings.Split(r.Header.Get(HeaderTraceparent), "-")
+	if len(parts) == 4 && len(parts[1]) == 32 && validRequestID(parts[1]) && parts[1] != strings.Repeat("0", 32) {
+		return parts[1]
+	}
+	return ""
+}
+
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bs)
+}
@@ -0,0 +1,279 @@
+package mux
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter wraps an http.ResponseWriter to record the status and the
+// number of bytes written. It implements http.Flusher, http.Hijacker,
+// http.Pusher and io.ReaderFrom; use Expose to hand it to a handler with
+// exactly the optional interfaces of the underlying writer.
+type ResponseWriter struct {
+	w           http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+	hijacked    bool
+	before      []func(*ResponseWriter)
+}
+
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{w: w}
+}
+
+// BeforeWrite registers f to be called once, just before the header is
+// written. Hooks run in the order they were registered and may modify the
+// header.
+func (w *ResponseWriter) BeforeWrite(f func(*ResponseWriter)) {
+	w.before = append(w.before, f)
+}
+
+func (w *ResponseWriter) Header() http.Header {
+	return w.w.Header()
+}
+
+func (w *ResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader || w.hijacked {
+		return
+	}
+	if statusCode >= 100 && statusCode < 200 {
+		// informational responses do not commit the header
+		w.w.WriteHeader(statusCode)
+		return
+	}
+	w.status = statusCode
+	hooks := w.before
+	w.before = nil
+	for _, f := range hooks {
+		f(w)
+	}
+	w.wroteHeader = true
+	w.w.WriteHeader(w.status)
+}
+
+func (w *ResponseWriter) Write(bs []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.w.Write(bs)
+	w.size += int64(n)
+	return n, err
+}
+
+// Status returns the status code written so far, or 0 if the header has not
+// been written.
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+// Size returns the number of body bytes written.
+func (w *ResponseWriter) Size() int64 {
+	return w.size
+}
+
+// Written reports whether the header has been written.
+func (w *ResponseWriter) Written() bool {
+	return w.wroteHeader || w.hijacked
+}
+
+func (w *ResponseWriter) Hijacked() bool {
+	return w.hijacked
+}
+
+// Unwrap returns the underlying writer, see http.ResponseController.
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
+func (w *ResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking not supported")
+	}
+	conn, brw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, brw, err
+}
+
+func (w *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.w.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (w *ResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if rf, ok := w.w.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(r)
+		w.size += n
+		return n, err
+	}
+	return io.Copy(writerOnly{w}, r)
+}
+
+// Expose returns w as an http.ResponseWriter that implements exactly those of
+// http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom that the
+// underlying writer implements.
+func (w *ResponseWriter) Expose() http.ResponseWriter {
+	return expose(w, w.w)
+}
+
+// writerOnly hides io.ReaderFrom to keep io.Copy from recursing.
+type writerOnly struct {
+	io.Writer
+}
+
+type unwrapper interface {
+	Unwrap() http.ResponseWriter
+}
+
+type fullResponseWriter interface {
+	http.ResponseWriter
+	unwrapper
+	http.Flusher
+	http.Hijacker
+	http.Pusher
+	io.ReaderFrom
+}
+
+// expose restricts w to the optional interfaces implemented by under.
+func expose(w fullResponseWriter, under http.ResponseWriter) http.ResponseWriter {
+	_, f := under.(http.Flusher)
+	_, h := under.(http.Hijacker)
+	_, p := under.(http.Pusher)
+	_, rf := under.(io.ReaderFrom)
+	switch {
+	case f && h && p && rf:
+		return w
+	case f && h && p:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w, w}
+	case f && h && rf:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w, w}
+	case f && p && rf:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w, w}
+	case h && p && rf:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w, w}
+	case f && h:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Hijacker
+		}{w, w, w, w}
+	case f && p:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Pusher
+		}{w, w, w, w}
+	case f && rf:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case h && p:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w}
+	case h && rf:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w}
+	case p && rf:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w}
+	case f:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+		}{w, w, w}
+	case h:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Hijacker
+		}{w, w, w}
+	case p:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Pusher
+		}{w, w, w}
+	case rf:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			io.ReaderFrom
+		}{w, w, w}
+	default:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+		}{w, w}
+	}
+}
+
+// cloneHeader returns a deep copy of h.
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, vs := range h {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
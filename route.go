@@ -11,6 +11,7 @@ func NewRoute(path string) *Route {
 		path:     path,
 		kind:     ClassifyKind(path),
 		handlers: map[string]http.Handler{},
+		meta:     map[string]string{},
 	}
 }
 
@@ -20,6 +21,9 @@ type Route struct {
 	kind     Kind
 	children Routes
 	handlers map[string]http.Handler
+	meta     map[string]string
+	cors     *AccessControl
+	cache    *CachePolicy
 }
 
 func (r *Route) IsRoot() bool {
@@ -142,6 +146,58 @@ func (r *Route) Handler(m string) (http.Handler, bool) {
 	return h, ok
 }
 
+func (r *Route) SetMeta(key string, value string) {
+	if r.meta == nil {
+		r.meta = map[string]string{}
+	}
+	r.meta[key] = value
+}
+
+func (r *Route) Meta(key string) (string, bool) {
+	v, ok := r.meta[key]
+	return v, ok
+}
+
+func (r *Route) Metadata() map[string]string {
+	md := map[string]string{}
+	for k, v := range r.meta {
+		md[k] = v
+	}
+	return md
+}
+
+// SetAccessControl attaches a CORS policy to r and all routes below it that do
+// not have their own.
+func (r *Route) SetAccessControl(ac AccessControl) {
+	r.cors = &ac
+}
+
+// AccessControl returns the CORS policy of r or of its closest ancestor.
+func (r *Route) AccessControl() (AccessControl, bool) {
+	for n := r; n != nil; n = n.parent {
+		if n.cors != nil {
+			return *n.cors, true
+		}
+	}
+	return AccessControl{}, false
+}
+
+// SetCachePolicy attaches a cache policy to r and all routes below it that do
+// not have their own. The router applies it the same way as CacheControl.
+func (r *Route) SetCachePolicy(p CachePolicy) {
+	r.cache = &p
+}
+
+// CachePolicy returns the cache policy of r or of its closest ancestor.
+func (r *Route) CachePolicy() (CachePolicy, bool) {
+	for n := r; n != nil; n = n.parent {
+		if n.cache != nil {
+			return *n.cache, true
+		}
+	}
+	return CachePolicy{}, false
+}
+
 func (r *Route) Methods() []string {
 	ms := []string{}
 	for m, _ := range r.handlers {
@@ -165,6 +221,9 @@ func (r *Route) Set(nr *Route) error {
 		c.parent = r
 	}
 	r.handlers = nr.handlers
+	r.meta = nr.meta
+	r.cors = nr.cors
+	r.cache = nr.cache
 	return nil
 }
 
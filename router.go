@@ -2,18 +2,24 @@ package mux
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"context"
 )
 
+const (
+	HeaderAllow = "Allow"
+)
+
 func New() *Router {
 	return &Router{}
 }
 
 type Router struct {
-	root *Route
+	root     *Route
+	renderer ErrorRenderer
 }
 
 func (r *Router) Route(path string) *Route {
@@ -21,6 +27,7 @@ func (r *Router) Route(path string) *Route {
 		r.root = &Route{
 			path:     "/",
 			handlers: map[string]http.Handler{},
+			meta:     map[string]string{},
 		}
 	}
 	if strings.HasPrefix(path, "/") {
@@ -29,36 +36,96 @@ func (r *Router) Route(path string) *Route {
 	return r.root.Route(path)
 }
 
+func (r *Router) Root() *Route {
+	return r.root
+}
+
+func (r *Router) SetErrorRenderer(render ErrorRenderer) {
+	r.renderer = render
+}
+
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req = withErrorRenderer(req, r.renderer)
 	if r.root == nil {
-		http.NotFound(w, req)
+		WriteError(w, req, ErrNotFound)
 		return
 	}
 	route, vars := r.root.Match(req.URL.Path[1:])
 	if route == nil {
-		http.NotFound(w, req)
+		WriteError(w, req, ErrNotFound)
 		return
 	}
+	req = withRoute(req, route)
+	ac, hasPolicy := route.AccessControl()
 	h, ok := route.Handler(req.Method)
 	if !ok {
 		// options
 		if req.Method == "OPTIONS" {
-			ac := AccessControlDefaults
-			ac.AllowMethods = route.Methods()
-			ac.AllowMethods = append(ac.AllowMethods, "OPTIONS")
-			SetAllCORSHeaders(w, req, ac)
-			w.WriteHeader(http.StatusNoContent)
+			r.options(w, req, route, ac, hasPolicy)
 			return
 		}
-		http.NotFound(w, req)
+		WriteError(w, req, ErrNotFound)
 		return
 	}
+	if origin := req.Header.Get(HeaderOrigin); hasPolicy && origin != "" {
+		setActualCORSHeaders(w, origin, ac)
+	}
 	for k, v := range vars {
 		req = req.WithContext(context.WithValue(req.Context(), k, v))
 	}
+	if p, ok := route.CachePolicy(); ok {
+		p.serve(h, w, req)
+		return
+	}
 	h.ServeHTTP(w, req)
 }
 
+// options answers an OPTIONS request for a route without an OPTIONS handler.
+// Preflight requests are validated against the route's CORS policy, limited
+// to the methods actually registered on the route.
+func (r *Router) options(w http.ResponseWriter, req *http.Request, route *Route, ac AccessControl, hasPolicy bool) {
+	methods := append(route.Methods(), "OPTIONS")
+	w.Header().Set(HeaderAllow, strings.Join(methods, ", "))
+	if !hasPolicy {
+		ac = AccessControlDefaults
+		ac.AllowMethods = methods
+		SetAllCORSHeaders(w, req, ac)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.Header.Get(HeaderOrigin) == "" || req.Header.Get(HeaderAccessControlRequestMethod) == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if len(ac.AllowMethods) == 0 || (containsString(ac.AllowMethods, "*") && !ac.AllowCredentials) {
+		// like allowsMethod, a wildcard without credentials allows any method
+		ac.AllowMethods = methods
+	} else {
+		ac.AllowMethods = intersection(methods, ac.AllowMethods)
+	}
+	// registered methods are authoritative, even for CORS-safelisted ones
+	if acrm := req.Header.Get(HeaderAccessControlRequestMethod); !containsString(ac.AllowMethods, acrm) {
+		AddVary(w.Header(), HeaderOrigin, HeaderAccessControlRequestMethod, HeaderAccessControlRequestHeaders)
+		WriteError(w, req, NewHTTPError(http.StatusForbidden, "cors_method_not_allowed", fmt.Sprintf("method %s not allowed", acrm)))
+		return
+	}
+	preflight(w, req, ac)
+}
+
+// intersection returns the elements of a that are also in b.
+func intersection(a []string, b []string) []string {
+	i := []string{}
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				i = append(i, x)
+				break
+			}
+		}
+	}
+	return i
+}
+
 func (r *Router) String() string {
 	var buf bytes.Buffer
 	buf.WriteString(strings.Repeat("-", 75))
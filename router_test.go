@@ -0,0 +1,65 @@
+package mux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoutePreflight(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name    string
+		ac      *AccessControl
+		method  string
+		status  int
+		methods string
+	}{
+		{"without policy", nil, "PUT", http.StatusNoContent, "DELETE, PUT, OPTIONS"},
+		{"policy without methods", &AccessControl{AllowOrigin: "*"}, "PUT", http.StatusNoContent, "DELETE, PUT, OPTIONS"},
+		{"policy limits methods", &AccessControl{AllowOrigin: "*", AllowMethods: []string{"PUT"}}, "DELETE", http.StatusForbidden, ""},
+		{"unregistered method", &AccessControl{AllowOrigin: "*", AllowMethods: []string{"PATCH", "PUT"}}, "PATCH", http.StatusForbidden, ""},
+		{"unregistered safelisted method", &AccessControl{AllowOrigin: "*"}, "POST", http.StatusForbidden, ""},
+		{"wildcard", &AccessControl{AllowOrigin: "*", AllowMethods: []string{"*"}}, "PUT", http.StatusNoContent, "DELETE, PUT, OPTIONS"},
+		{"wildcard with credentials", &AccessControl{AllowOrigin: "*", AllowCredentials: true, AllowMethods: []string{"*"}}, "PUT", http.StatusForbidden, ""},
+		{"origin", &AccessControl{AllowOrigins: []string{"https://other.example"}}, "PUT", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			route := r.Route("/items/:id")
+			route.PUT(ok)
+			route.DELETE(ok)
+			if tt.ac != nil {
+				route.SetAccessControl(*tt.ac)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, preflightRequest("/items/1", "https://app.example", tt.method, ""))
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if got := w.Header().Get(HeaderAllow); got != "DELETE, PUT, OPTIONS" {
+				t.Errorf("unexpected Allow %q", got)
+			}
+			if got := w.Header().Get(HeaderAccessControlAllowMethods); got != tt.methods {
+				t.Errorf("expected allow methods %q, got %q", tt.methods, got)
+			}
+		})
+	}
+}
+
+func TestRouteAccessControlInherited(t *testing.T) {
+	r := New()
+	r.Route("/api").SetAccessControl(AccessControl{AllowOrigins: []string{"https://app.example"}})
+	r.Route("/api/items").GET(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, origin := range []string{"https://app.example", "https://evil.example"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+		req.Header.Set(HeaderOrigin, origin)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		allowed := w.Header().Get(HeaderAccessControlAllowOrigin) == origin
+		if allowed != (origin == "https://app.example") {
+			t.Errorf("%s: unexpected allow origin %q", origin, w.Header().Get(HeaderAccessControlAllowOrigin))
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package mux
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderLastEventID  = "Last-Event-ID"
+	HeaderCacheControl = "Cache-Control"
+)
+
+const (
+	ContentTypeEventStream = "text/event-stream"
+)
+
+// Event is a rendered state of a resource as sent to event stream clients.
+type Event struct {
+	ID   uint64
+	Data []byte
+	Time time.Time
+}
+
+// ReplayBuffer sets how many events per key are kept for clients resuming
+// with a Last-Event-ID header.
+func ReplayBuffer(n int) func(*Manager) error {
+	return func(m *Manager) error {
+		if n < 0 {
+			return fmt.Errorf("replay buffer must not be negative")
+		}
+		m.replay = n
+		return nil
+	}
+}
+
+// Heartbeat sets the interval of comments sent to keep event streams open.
+func Heartbeat(d time.Duration) func(*Manager) error {
+	return func(m *Manager) error {
+		if d <= 0 {
+			return fmt.Errorf("heartbeat must be positive")
+		}
+		m.heartbeat = d
+		return nil
+	}
+}
+
+// EventStream serves requests accepting text/event-stream as Server-Sent
+// Events. The resource is rendered by next whenever its key is signaled and
+// sent as an event if it changed. Other requests are passed to next.
+func (m *Manager) EventStream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get(HeaderAccept), ContentTypeEventStream) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			WriteError(w, r, NewHTTPError(http.StatusNotAcceptable, "streaming_unsupported", "streaming is not supported"))
+			return
+		}
+		key := m.key(r)
+
+		wake := make(chan struct{}, 1)
+		cancel := m.Listen(func(signal string) {
+			if m.matches(key, signal) {
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			}
+		})
+		defer cancel()
+
+		w.Header().Set(HeaderContentType, ContentTypeEventStream)
+		w.Header().Set(HeaderCacheControl, "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		var lastID uint64
+		if id, err := strconv.ParseUint(r.Header.Get(HeaderLastEventID), 10, 64); err == nil {
+			// unknown ids, e.g. from another instance, get the current state
+			if es, ok := m.eventsSince(key, id); ok {
+				lastID = id
+				for _, e := range es {
+					writeEvent(w, e)
+					lastID = e.ID
+				}
+			}
+		}
+		render := func() bool {
+			e, ok := m.renderEvent(next, r, key)
+			if !ok {
+				return false
+			}
+			if e.ID > lastID {
+				writeEvent(w, e)
+				lastID = e.ID
+			}
+			flusher.Flush()
+			return true
+		}
+		if !render() {
+			return
+		}
+
+		heartbeat := time.NewTicker(m.heartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-m.done:
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			case <-wake:
+				if !render() {
+					return
+				}
+			}
+		}
+	})
+}
+
+// renderEvent renders the resource with next and records it as a new event
+// unless it equals the latest event of key.
+func (m *Manager) renderEvent(next http.Handler, r *http.Request, key string) (Event, bool) {
+	rr := r.WithContext(r.Context())
+	rr.Header = cloneHeader(r.Header)
+	rr.Header.Del(HeaderAccept)
+	rr.Header.Del(HeaderIfNoneMatch)
+	rr.Header.Del(HeaderLongPoll)
+	rec := &eventRecorder{header: http.Header{}, status: http.StatusOK}
+	next.ServeHTTP(rec, rr)
+	if rec.status != http.StatusOK {
+		return Event{}, false
+	}
+	return m.recordEvent(key, rec.body.Bytes()), true
+}
+
+func (m *Manager) recordEvent(key string, data []byte) Event {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	es := m.events[key]
+	if len(es) > 0 && bytes.Equal(es[len(es)-1].Data, data) {
+		return es[len(es)-1]
+	}
+	m.eventID++
+	e := Event{ID: m.eventID, Data: data, Time: time.Now()}
+	es = append(es, e)
+	// the latest event is always kept to detect changes
+	if keep := m.replay; len(es) > keep && len(es) > 1 {
+		if keep < 1 {
+			keep = 1
+		}
+		es = es[len(es)-keep:]
+	}
+	m.events[key] = es
+	m.sweep()
+	return e
+}
+
+// eventsSince returns the buffered events of key after id. It reports false
+// if id is not in the buffer, so the events since cannot be told.
+func (m *Manager) eventsSince(key string, id uint64) ([]Event, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	res := []Event{}
+	known := false
+	for _, e := range m.events[key] {
+		if e.ID == id {
+			known = true
+		}
+		if e.ID > id {
+			res = append(res, e)
+		}
+	}
+	return res, known
+}
+
+func writeEvent(w http.ResponseWriter, e Event) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "id: %d\n", e.ID)
+	for _, line := range strings.Split(strings.TrimSuffix(string(e.Data), "\n"), "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	w.Write(buf.Bytes())
+}
+
+type eventRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *eventRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *eventRecorder) WriteHeader(statusCode int) {
+	r.status = statusCode
+}
+
+func (r *eventRecorder) Write(bs []byte) (int, error) {
+	return r.body.Write(bs)
+}
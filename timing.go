@@ -1,29 +1,136 @@
 package mux
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	HeaderServerTiming = "Server-Timing"
+	HeaderTrailer      = "Trailer"
+)
+
+const (
+	timingKey contextKey = "timing"
+)
+
 func NewTiming() *Timing {
+	now := time.Now()
 	return &Timing{
-		time: time.Now(),
+		start: now,
+		time:  now,
 	}
 }
 
+// Timing collects Server-Timing metrics. It is safe for concurrent use.
 type Timing struct {
+	mutex sync.Mutex
+	start time.Time
 	time  time.Time
 	marks []string
 }
 
+// Mark records the time since the previous mark (or creation) as key.
 func (ts *Timing) Mark(key string) {
-	ms := float64(time.Since(ts.time)) / float64(time.Millisecond)
-	ts.marks = append(ts.marks, fmt.Sprintf("%s=%.1f", key, ms))
-	ts.time = time.Now()
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	now := time.Now()
+	ts.marks = append(ts.marks, formatMetric(key, now.Sub(ts.time), ""))
+	ts.time = now
+}
+
+// Add records a metric with an optional description.
+func (ts *Timing) Add(name string, d time.Duration, desc string) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.marks = append(ts.marks, formatMetric(name, d, desc))
+}
+
+// Start begins measuring a metric that is recorded when the returned function
+// is called.
+func (ts *Timing) Start(name string, desc string) func() {
+	start := time.Now()
+	return func() {
+		ts.Add(name, time.Since(start), desc)
+	}
+}
+
+// Total returns the time since the Timing was created.
+func (ts *Timing) Total() time.Duration {
+	return time.Since(ts.start)
+}
+
+func (ts *Timing) String() string {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	return strings.Join(ts.marks, ", ")
 }
 
 func (ts *Timing) WriteTo(header http.Header) {
-	header.Add("Server-Timing", strings.Join(ts.marks, ", "))
+	if s := ts.String(); s != "" {
+		header.Add(HeaderServerTiming, s)
+	}
+}
+
+func formatMetric(name string, d time.Duration, desc string) string {
+	ms := float64(d) / float64(time.Millisecond)
+	m := fmt.Sprintf("%s;dur=%.1f", name, ms)
+	if desc != "" {
+		m += `;desc="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(desc) + `"`
+	}
+	return m
+}
+
+// TimingFromContext returns the Timing placed in the request context by
+// ServerTiming, or nil.
+func TimingFromContext(ctx context.Context) *Timing {
+	ts, _ := ctx.Value(timingKey).(*Timing)
+	return ts
+}
+
+type ServerTimingConfig struct {
+	// Trailer sends the metrics as a trailer after the body, so that metrics
+	// recorded while writing the body are included.
+	Trailer bool
+}
+
+func ServerTiming(h http.Handler) http.Handler {
+	return ServerTimingWith(ServerTimingConfig{})(h)
+}
+
+// ServerTimingWith places a Timing in the request context and emits its
+// metrics, together with the total time, in the Server-Timing header just
+// before the header is written.
+func ServerTimingWith(c ServerTimingConfig) Decorator {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ts := NewTiming()
+			r = r.WithContext(context.WithValue(r.Context(), timingKey, ts))
+			rw := NewResponseWriter(w)
+			emit := func(h http.Header) {
+				ts.Add("total", ts.Total(), "")
+				ts.WriteTo(h)
+			}
+			if c.Trailer {
+				w.Header().Add(HeaderTrailer, HeaderServerTiming)
+			} else {
+				rw.BeforeWrite(func(rw *ResponseWriter) {
+					emit(rw.Header())
+				})
+			}
+
+			next.ServeHTTP(rw.Expose(), r)
+
+			if rw.Hijacked() {
+				return
+			}
+			if c.Trailer || !rw.Written() {
+				emit(w.Header())
+			}
+		})
+	}
 }
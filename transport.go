@@ -0,0 +1,311 @@
+package mux
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MessageSignal wakes the keys concerned by a prefix.
+	MessageSignal = "signal"
+	// MessageInvalidate drops the ETags recorded for the keys concerned by a
+	// prefix without waking waiters.
+	MessageInvalidate = "invalidate"
+)
+
+// TransportMessage is distributed between Managers by a Transport.
+type TransportMessage struct {
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Origin string `json:"origin"`
+}
+
+// Transport distributes signals between Manager instances, e.g. replicas
+// behind a load balancer. Managers apply their own signals locally and ignore
+// messages they published themselves.
+type Transport interface {
+	Publish(msg TransportMessage) error
+	Subscribe(f func(msg TransportMessage)) (cancel func())
+}
+
+// SignalTransport sets the transport used to distribute signals. By default
+// every Manager uses a MemoryTransport of its own.
+func SignalTransport(t Transport) func(*Manager) error {
+	return func(m *Manager) error {
+		if t == nil {
+			return fmt.Errorf("transport must not be nil")
+		}
+		if m.unsubscribe != nil {
+			m.unsubscribe()
+		}
+		m.transport = t
+		m.unsubscribe = t.Subscribe(m.receive)
+		return nil
+	}
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{subscribers: map[int]func(TransportMessage){}}
+}
+
+// MemoryTransport delivers messages synchronously within the process. Share
+// one between Managers to connect them.
+type MemoryTransport struct {
+	mutex       sync.RWMutex
+	subscribers map[int]func(TransportMessage)
+	next        int
+}
+
+func (t *MemoryTransport) Publish(msg TransportMessage) error {
+	t.mutex.RLock()
+	fs := make([]func(TransportMessage), 0, len(t.subscribers))
+	for _, f := range t.subscribers {
+		fs = append(fs, f)
+	}
+	t.mutex.RUnlock()
+	for _, f := range fs {
+		f(msg)
+	}
+	return nil
+}
+
+func (t *MemoryTransport) Subscribe(f func(msg TransportMessage)) func() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.next++
+	id := t.next
+	t.subscribers[id] = f
+	return func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		delete(t.subscribers, id)
+	}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		conns:     map[net.Conn]chan []byte{},
+		listeners: map[net.Listener]bool{},
+	}
+}
+
+// Broker relays messages between BrokerTransports connected over TCP or a
+// Unix socket. Messages are newline delimited JSON; every message is sent to
+// all connections except the one it was received from.
+type Broker struct {
+	mutex     sync.Mutex
+	conns     map[net.Conn]chan []byte
+	listeners map[net.Listener]bool
+	closed    bool
+}
+
+// ListenAndServe listens on the given network ("tcp" or "unix") and address
+// and relays messages until the Broker is closed.
+func (b *Broker) ListenAndServe(network string, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return b.Serve(l)
+}
+
+func (b *Broker) Serve(l net.Listener) error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		l.Close()
+		return fmt.Errorf("broker closed")
+	}
+	b.listeners[l] = true
+	b.mutex.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			b.mutex.Lock()
+			closed := b.closed
+			b.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go b.serveConn(conn)
+	}
+}
+
+func (b *Broker) serveConn(conn net.Conn) {
+	out := make(chan []byte, 256)
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	b.conns[conn] = out
+	b.mutex.Unlock()
+
+	go func() {
+		for line := range out {
+			if _, err := conn.Write(line); err != nil {
+				conn.Close()
+			}
+		}
+	}()
+	defer func() {
+		b.mutex.Lock()
+		if _, ok := b.conns[conn]; ok {
+			delete(b.conns, conn)
+			close(out)
+		}
+		b.mutex.Unlock()
+		conn.Close()
+	}()
+
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		line := append(append([]byte{}, s.Bytes()...), '\n')
+		b.mutex.Lock()
+		for c, o := range b.conns {
+			if c == conn {
+				continue
+			}
+			select {
+			case o <- line:
+			default:
+				// drop connections that do not keep up
+				delete(b.conns, c)
+				close(o)
+				c.Close()
+			}
+		}
+		b.mutex.Unlock()
+	}
+}
+
+// Close stops all listeners and closes every connection.
+func (b *Broker) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for l := range b.listeners {
+		l.Close()
+	}
+	for c, o := range b.conns {
+		delete(b.conns, c)
+		close(o)
+		c.Close()
+	}
+	return nil
+}
+
+// DialBroker connects a transport to a Broker. The connection is
+// re-established when it is lost; messages published while disconnected fail.
+func DialBroker(network string, address string) (*BrokerTransport, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	t := &BrokerTransport{
+		network: network,
+		address: address,
+		conn:    conn,
+		local:   NewMemoryTransport(),
+		retry:   time.Second,
+		closing: make(chan struct{}),
+	}
+	go t.run(conn)
+	return t, nil
+}
+
+// BrokerTransport is a Transport connected to a Broker.
+type BrokerTransport struct {
+	network   string
+	address   string
+	mutex     sync.Mutex
+	conn      net.Conn
+	local     *MemoryTransport
+	retry     time.Duration
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *BrokerTransport) Publish(msg TransportMessage) error {
+	bs, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conn == nil {
+		return fmt.Errorf("not connected to broker %s", t.address)
+	}
+	t.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err = t.conn.Write(append(bs, '\n'))
+	return err
+}
+
+func (t *BrokerTransport) Subscribe(f func(msg TransportMessage)) func() {
+	return t.local.Subscribe(f)
+}
+
+// Close disconnects from the broker.
+func (t *BrokerTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closing)
+		t.mutex.Lock()
+		if t.conn != nil {
+			t.conn.Close()
+			t.conn = nil
+		}
+		t.mutex.Unlock()
+	})
+	return nil
+}
+
+// run delivers received messages to the subscribers and reconnects when the
+// connection is lost.
+func (t *BrokerTransport) run(conn net.Conn) {
+	for {
+		s := bufio.NewScanner(conn)
+		for s.Scan() {
+			var msg TransportMessage
+			if err := json.Unmarshal(s.Bytes(), &msg); err != nil {
+				continue
+			}
+			t.local.Publish(msg)
+		}
+		t.mutex.Lock()
+		if t.conn == conn {
+			t.conn.Close()
+			t.conn = nil
+		}
+		t.mutex.Unlock()
+		for {
+			select {
+			case <-t.closing:
+				return
+			case <-time.After(t.retry):
+			}
+			c, err := net.Dial(t.network, t.address)
+			if err != nil {
+				continue
+			}
+			t.mutex.Lock()
+			select {
+			case <-t.closing:
+				t.mutex.Unlock()
+				c.Close()
+				return
+			default:
+			}
+			t.conn = c
+			t.mutex.Unlock()
+			conn = c
+			break
+		}
+	}
+}
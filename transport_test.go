@@ -0,0 +1,193 @@
+package mux
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startBroker(t *testing.T, network string, address string) (*Broker, string) {
+	t.Helper()
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBroker()
+	go b.Serve(l)
+	return b, l.Addr().String()
+}
+
+func dialBroker(t *testing.T, network string, address string) *BrokerTransport {
+	t.Helper()
+	bt, err := DialBroker(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bt
+}
+
+func newTransportManager(t *testing.T, tr Transport) *Manager {
+	t.Helper()
+	m, err := NewManager(SignalTransport(tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func expectWoken(t *testing.T, s Signal) {
+	t.Helper()
+	select {
+	case <-s:
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter was not woken")
+	}
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManagersAreIsolatedByDefault(t *testing.T) {
+	a, _ := NewManager()
+	b, _ := NewManager()
+	s := b.Await("/items")
+	a.Signal("/items")
+	select {
+	case <-s:
+		t.Fatal("signal crossed to an unconnected manager")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	tr := NewMemoryTransport()
+	a := newTransportManager(t, tr)
+	b := newTransportManager(t, tr)
+
+	var signals int64
+	cancel := a.Listen(func(string) { atomic.AddInt64(&signals, 1) })
+	defer cancel()
+
+	s := b.Await("/items/1")
+	a.Signal("/items")
+	expectWoken(t, s)
+	if n := atomic.LoadInt64(&signals); n != 1 {
+		// the publisher must ignore its own message
+		t.Fatalf("expected 1 local signal, got %d", n)
+	}
+}
+
+func TestBrokerTransportTCP(t *testing.T) {
+	broker, addr := startBroker(t, "tcp", "127.0.0.1:0")
+	defer broker.Close()
+	testBrokerTransport(t, "tcp", addr)
+}
+
+func TestBrokerTransportUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	broker, addr := startBroker(t, "unix", filepath.Join(dir, "broker.sock"))
+	defer broker.Close()
+	testBrokerTransport(t, "unix", addr)
+}
+
+func testBrokerTransport(t *testing.T, network string, addr string) {
+	ta := dialBroker(t, network, addr)
+	defer ta.Close()
+	tb := dialBroker(t, network, addr)
+	defer tb.Close()
+	a := newTransportManager(t, ta)
+	b := newTransportManager(t, tb)
+
+	s := b.Await("/items/1")
+	eventually(t, func() bool {
+		// the broker may not have registered both connections yet
+		a.Signal("/items")
+		select {
+		case <-s:
+			return true
+		default:
+			return false
+		}
+	})
+
+	s = b.Await("/items/2")
+	a.Signal("/items/2")
+	expectWoken(t, s)
+
+	b.recordEtag(time.Now(), "session", "/orders/1", `"v1"`)
+	a.Invalidate("/orders")
+	eventually(t, func() bool {
+		return b.etag("session", "/orders/1") == ""
+	})
+}
+
+func TestBrokerTransportReconnects(t *testing.T) {
+	broker, addr := startBroker(t, "tcp", "127.0.0.1:0")
+	ta := dialBroker(t, "tcp", addr)
+	defer ta.Close()
+	tb := dialBroker(t, "tcp", addr)
+	defer tb.Close()
+	a := newTransportManager(t, ta)
+	b := newTransportManager(t, tb)
+
+	var errs int64
+	a.SetOption(TransportErrors(func(error) { atomic.AddInt64(&errs, 1) }))
+
+	broker.Close()
+	eventually(t, func() bool {
+		a.Signal("/lost")
+		return atomic.LoadInt64(&errs) > 0
+	})
+
+	broker, _ = startBroker(t, "tcp", addr)
+	defer broker.Close()
+
+	s := b.Await("/items")
+	eventually(t, func() bool {
+		a.Signal("/items")
+		select {
+		case <-s:
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+func TestBrokerRelaysToOthersOnly(t *testing.T) {
+	broker, addr := startBroker(t, "tcp", "127.0.0.1:0")
+	defer broker.Close()
+	ta := dialBroker(t, "tcp", addr)
+	defer ta.Close()
+	tb := dialBroker(t, "tcp", addr)
+	defer tb.Close()
+
+	var own, other int64
+	ta.Subscribe(func(TransportMessage) { atomic.AddInt64(&own, 1) })
+	tb.Subscribe(func(TransportMessage) { atomic.AddInt64(&other, 1) })
+
+	eventually(t, func() bool {
+		ta.Publish(TransportMessage{Kind: MessageSignal, Key: "/x", Origin: "a"})
+		return atomic.LoadInt64(&other) > 0
+	})
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt64(&own); n != 0 {
+		t.Fatalf("publisher received %d of its own messages", n)
+	}
+}
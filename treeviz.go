@@ -2,8 +2,12 @@ package mux
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"html/template"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -75,3 +79,109 @@ func contains(s []int, e int) bool {
 	}
 	return false
 }
+
+type RouteInfo struct {
+	Path     string            `json:"path"`
+	Kind     string            `json:"kind"`
+	FullPath string            `json:"fullPath"`
+	Methods  []string          `json:"methods,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Children []*RouteInfo      `json:"children,omitempty"`
+}
+
+func Describe(n *Route) *RouteInfo {
+	if n == nil {
+		return nil
+	}
+	info := &RouteInfo{
+		Path:     n.path,
+		Kind:     n.kind.String(),
+		FullPath: n.FullPath(),
+		Methods:  n.Methods(),
+	}
+	if len(n.meta) > 0 {
+		info.Metadata = n.Metadata()
+	}
+	children := n.children
+	sort.Sort(children)
+	for _, c := range children {
+		info.Children = append(info.Children, Describe(c))
+	}
+	return info
+}
+
+func TreeJSON(n *Route) ([]byte, error) {
+	return json.MarshalIndent(Describe(n), "", "  ")
+}
+
+func ParseTreeJSON(bs []byte) (*RouteInfo, error) {
+	info := &RouteInfo{}
+	if err := json.Unmarshal(bs, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func TreeDOT(n *Route) string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph routes {\n")
+	buf.WriteString("  rankdir=LR;\n")
+	buf.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	if info := Describe(n); info != nil {
+		id := 0
+		writeDOT(&buf, info, &id)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+func writeDOT(buf *bytes.Buffer, info *RouteInfo, id *int) string {
+	name := fmt.Sprintf("n%d", *id)
+	*id++
+	label := fmt.Sprintf("%s [%s]", info.Path, info.Kind)
+	if len(info.Methods) > 0 {
+		label += "\n" + strings.Join(info.Methods, ", ")
+	}
+	style := ""
+	if len(info.Methods) > 0 {
+		style = ", style=bold"
+	}
+	buf.WriteString(fmt.Sprintf("  %s [label=%s, tooltip=%s%s];\n", name, strconv.Quote(label), strconv.Quote(info.FullPath), style))
+	for _, c := range info.Children {
+		cName := writeDOT(buf, c, id)
+		buf.WriteString(fmt.Sprintf("  %s -> %s;\n", name, cName))
+	}
+	return name
+}
+
+func TreeHTML(n *Route) (string, error) {
+	var buf bytes.Buffer
+	if err := treeHTMLTemplate.Execute(&buf, Describe(n)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+var treeHTMLTemplate = template.Must(template.New("tree").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Routes</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+ul { list-style: none; padding-left: 1.5em; border-left: 1px dotted #aaa; }
+ul.root { border-left: none; padding-left: 0; }
+.path { font-family: monospace; font-weight: bold; }
+.kind { color: #888; font-size: 0.8em; }
+.method { display: inline-block; font-family: monospace; font-size: 0.8em; padding: 0 0.4em; margin-left: 0.3em; border-radius: 3px; background: #e0e8f0; }
+.meta { color: #666; font-size: 0.8em; margin-left: 0.5em; }
+</style>
+</head>
+<body>
+<h1>Routes</h1>
+{{if .}}<ul class="root">{{template "node" .}}</ul>{{end}}
+</body>
+</html>
+{{define "node"}}<li title="{{.FullPath}}"><span class="path">{{.Path}}</span> <span class="kind">[{{.Kind}}]</span>{{range .Methods}}<span class="method">{{.}}</span>{{end}}{{range $k, $v := .Metadata}}<span class="meta">{{$k}}={{$v}}</span>{{end}}
+{{if .Children}}<ul>{{range .Children}}{{template "node" .}}{{end}}</ul>{{end}}</li>
+{{end}}`))
@@ -0,0 +1,319 @@
+package mux
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	HeaderAccept = "Accept"
+)
+
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeXML  = "application/xml"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// JSON adapts fn, which must have the signature
+//
+//	func(context.Context, Req) (Resp, error)
+//
+// to an http.Handler. Req is a struct (or pointer to struct) whose fields are
+// bound from the JSON body and from the path, query and header values named by
+// the `path`, `query` and `header` struct tags. Resp is encoded according to
+// the Accept header of the request.
+func JSON(fn interface{}) http.Handler {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func ||
+		ft.NumIn() != 2 || ft.In(0) != contextType ||
+		ft.NumOut() != 2 || ft.Out(1) != errorType {
+		panic(fmt.Errorf("mux: JSON expects func(context.Context, Req) (Resp, error), got %s", ft))
+	}
+	reqType := ft.In(1)
+	if structType(reqType) == nil {
+		panic(fmt.Errorf("mux: JSON request type must be a struct or pointer to struct, got %s", reqType))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct, ok := negotiate(r.Header.Get(HeaderAccept), ContentTypeJSON, ContentTypeXML)
+		if !ok {
+			WriteError(w, r, NewHTTPError(http.StatusNotAcceptable, "not_acceptable", ""))
+			return
+		}
+		in, err := bind(r, reqType)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		out := fv.Call([]reflect.Value{reflect.ValueOf(r.Context()), in})
+		if errV := out[1]; !errV.IsNil() {
+			WriteError(w, r, errV.Interface().(error))
+			return
+		}
+		resp := out[0]
+		if isNil(resp) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set(HeaderContentType, ct+"; charset=utf-8")
+		status := http.StatusOK
+		if sc, ok := resp.Interface().(interface{ StatusCode() int }); ok {
+			status = sc.StatusCode()
+		}
+		w.WriteHeader(status)
+		if r.Method == http.MethodHead {
+			return
+		}
+		encode(w, ct, resp.Interface())
+	})
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+type BindError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *BindError) Error() string {
+	msgs := []string{}
+	for _, fe := range e.Errors {
+		if fe.Field == "" {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", fe.In, fe.Message))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("%s %s: %s", fe.In, fe.Field, fe.Message))
+		}
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+func (e *BindError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e *BindError) add(field string, in string, msg string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, In: in, Message: msg})
+}
+
+func bind(r *http.Request, t reflect.Type) (reflect.Value, error) {
+	st := structType(t)
+	v := reflect.New(st)
+	be := &BindError{}
+	if hasBody(r) {
+		mt, _, _ := mime.ParseMediaType(r.Header.Get(HeaderContentType))
+		switch {
+		case mt == "" || mt == ContentTypeJSON || strings.HasSuffix(mt, "+json"):
+			if err := json.NewDecoder(r.Body).Decode(v.Interface()); err != nil && err != io.EOF {
+				if _, ok := err.(interface{ StatusCode() int }); ok {
+					// e.g. ErrBodyTooLarge from reading the body
+					return v, err
+				}
+				be.add("", "body", err.Error())
+			}
+		default:
+			return v, NewHTTPError(http.StatusUnsupportedMediaType, "unsupported_media_type", fmt.Sprintf("unsupported content type %q", mt))
+		}
+	}
+	bindFields(r, v.Elem(), be)
+	if len(be.Errors) > 0 {
+		return v, be
+	}
+	if val, ok := v.Interface().(interface{ Validate() error }); ok {
+		if err := val.Validate(); err != nil {
+			if _, ok := err.(interface{ StatusCode() int }); ok {
+				return v, err
+			}
+			be.add("", "body", err.Error())
+			return v, be
+		}
+	}
+	if t.Kind() == reflect.Ptr {
+		return v, nil
+	}
+	return v.Elem(), nil
+}
+
+func bindFields(r *http.Request, v reflect.Value, be *BindError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			bindFields(r, fv, be)
+			continue
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name := f.Tag.Get("path"); name != "" {
+			if s, ok := pathVar(r.Context(), name); ok {
+				if err := setValue(fv, []string{s}); err != nil {
+					be.add(name, "path", err.Error())
+				}
+			}
+		}
+		if name := f.Tag.Get("query"); name != "" {
+			if ss, ok := r.URL.Query()[name]; ok {
+				if err := setValue(fv, ss); err != nil {
+					be.add(name, "query", err.Error())
+				}
+			}
+		}
+		if name := f.Tag.Get("header"); name != "" {
+			if ss, ok := r.Header[http.CanonicalHeaderKey(name)]; ok {
+				if err := setValue(fv, ss); err != nil {
+					be.add(name, "header", err.Error())
+				}
+			}
+		}
+	}
+}
+
+func pathVar(ctx context.Context, name string) (string, bool) {
+	for _, k := range []string{":" + name, "*" + name} {
+		if s, ok := ctx.Value(k).(string); ok {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+func setValue(v reflect.Value, ss []string) error {
+	if v.Kind() == reflect.Ptr {
+		nv := reflect.New(v.Type().Elem())
+		if err := setValue(nv.Elem(), ss); err != nil {
+			return err
+		}
+		v.Set(nv)
+		return nil
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		sv := reflect.MakeSlice(v.Type(), len(ss), len(ss))
+		for i, s := range ss {
+			if err := setValue(sv.Index(i), []string{s}); err != nil {
+				return err
+			}
+		}
+		v.Set(sv)
+		return nil
+	}
+	s := ss[0]
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// negotiate picks the offer with the highest quality in the Accept header.
+// The first offer is used when the header is absent.
+func negotiate(accept string, offers ...string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		for _, part := range strings.Split(accept, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if qs, ok := params["q"]; ok {
+				if f, err := strconv.ParseFloat(qs, 64); err == nil {
+					q = f
+				}
+			}
+			if q > bestQ && mediaTypeMatches(mt, offer) {
+				best, bestQ = offer, q
+			}
+		}
+	}
+	return best, best != ""
+}
+
+func mediaTypeMatches(pattern string, mt string) bool {
+	if pattern == "*/*" || pattern == mt {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mt, pattern[:len(pattern)-1])
+	}
+	return false
+}
+
+func encode(w io.Writer, ct string, v interface{}) error {
+	switch ct {
+	case ContentTypeXML:
+		return xml.NewEncoder(w).Encode(v)
+	default:
+		return json.NewEncoder(w).Encode(v)
+	}
+}
+
+func structType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// isNil reports whether v is a nil pointer or interface. Nil maps and slices
+// are encoded as they are.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
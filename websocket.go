@@ -0,0 +1,440 @@
+package mux
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderUpgrade             = "Upgrade"
+	HeaderConnection          = "Connection"
+	HeaderSecWebSocketKey     = "Sec-WebSocket-Key"
+	HeaderSecWebSocketVersion = "Sec-WebSocket-Version"
+	HeaderSecWebSocketAccept  = "Sec-WebSocket-Accept"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+const (
+	wsCloseNormal        = 1000
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+	wsCloseUnsupported   = 1003
+	wsCloseTooBig        = 1009
+	wsCloseTryAgainLater = 1013
+)
+
+// WebSocketMessage is exchanged as JSON text frames. Clients send "subscribe"
+// and "unsubscribe" messages for a key; with Prefix set, every key starting
+// with Key is subscribed. The server answers with "subscribed",
+// "unsubscribed" and "error" messages and sends a "signal" message for every
+// signal concerning a subscription.
+type WebSocketMessage struct {
+	Type   string `json:"type"`
+	Key    string `json:"key,omitempty"`
+	Prefix bool   `json:"prefix,omitempty"`
+	Signal string `json:"signal,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type WebSocketConfig struct {
+	// PingInterval is the interval of pings sent to the client (default 30s).
+	PingInterval time.Duration
+	// PongTimeout is how long after a ping the connection is kept without
+	// hearing from the client (default 10s).
+	PongTimeout time.Duration
+	// MaxSubscriptions limits the subscriptions per connection (default 100).
+	MaxSubscriptions int
+	// SendQueue is the number of messages queued for a slow client before the
+	// connection is closed (default 64).
+	SendQueue int
+	// MaxMessageSize limits the size of client messages (default 4KiB).
+	MaxMessageSize int64
+	// CheckOrigin rejects the handshake if it returns false. It defaults to
+	// SameOrigin, use AllowAllOrigins to accept connections from any site.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// SameOrigin reports whether the Origin header of r is absent or its host
+// equals the requested host.
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get(HeaderOrigin)
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// AllowAllOrigins accepts every origin. Browsers send cookies along with
+// cross-site WebSocket handshakes, so only use it for endpoints that do not
+// rely on them.
+func AllowAllOrigins(r *http.Request) bool {
+	return true
+}
+
+// WebSocket returns an endpoint where clients subscribe to multiple keys over
+// a single WebSocket connection and are notified of signals.
+func (m *Manager) WebSocket(c WebSocketConfig) http.Handler {
+	if c.PingInterval <= 0 {
+		c.PingInterval = 30 * time.Second
+	}
+	if c.PongTimeout <= 0 {
+		c.PongTimeout = 10 * time.Second
+	}
+	if c.MaxSubscriptions <= 0 {
+		c.MaxSubscriptions = 100
+	}
+	if c.SendQueue <= 0 {
+		c.SendQueue = 64
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = 4096
+	}
+	if c.CheckOrigin == nil {
+		c.CheckOrigin = SameOrigin
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := upgradeWebSocket(w, r, c)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		ws := &wsConn{
+			conn:    conn,
+			rw:      brw,
+			config:  c,
+			subs:    map[string]bool{},
+			send:    make(chan WebSocketMessage, c.SendQueue),
+			closing: make(chan struct{}),
+		}
+		cancel := m.Listen(func(signal string) {
+			ws.notify(m, signal)
+		})
+		defer cancel()
+		go ws.writeLoop(m.done)
+		ws.readLoop()
+	})
+}
+
+// upgradeWebSocket validates the handshake and takes over the connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, c WebSocketConfig) (net.Conn, *bufio.ReadWriter, error) {
+	if r.Method != http.MethodGet ||
+		!containsFold(splitList(r.Header.Get(HeaderConnection)), "upgrade") ||
+		!strings.EqualFold(r.Header.Get(HeaderUpgrade), "websocket") {
+		return nil, nil, NewHTTPError(http.StatusBadRequest, "websocket_handshake", "not a websocket handshake")
+	}
+	if r.Header.Get(HeaderSecWebSocketVersion) != "13" {
+		w.Header().Set(HeaderSecWebSocketVersion, "13")
+		return nil, nil, NewHTTPError(http.StatusUpgradeRequired, "websocket_version", "unsupported websocket version")
+	}
+	key := r.Header.Get(HeaderSecWebSocketKey)
+	if bs, err := base64.StdEncoding.DecodeString(key); err != nil || len(bs) != 16 {
+		return nil, nil, NewHTTPError(http.StatusBadRequest, "websocket_handshake", "invalid websocket key")
+	}
+	if !c.CheckOrigin(r) {
+		return nil, nil, NewHTTPError(http.StatusForbidden, "websocket_origin", "origin not allowed")
+	}
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, NewHTTPError(http.StatusInternalServerError, "websocket_unsupported", "connection cannot be hijacked")
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n%s: websocket\r\n%s: Upgrade\r\n%s: %s\r\n\r\n",
+		HeaderUpgrade, HeaderConnection, HeaderSecWebSocketAccept, base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, brw, nil
+}
+
+type wsConn struct {
+	conn        net.Conn
+	rw          *bufio.ReadWriter
+	config      WebSocketConfig
+	mutex       sync.Mutex
+	subs        map[string]bool
+	send        chan WebSocketMessage
+	writeLock   sync.Mutex
+	closeOnce   sync.Once
+	closing     chan struct{}
+	closeCode   int
+	closeReason string
+}
+
+// notify queues a signal message if it concerns a subscription. A client
+// that does not keep up is disconnected.
+func (ws *wsConn) notify(m *Manager, signal string) {
+	ws.mutex.Lock()
+	match := ""
+	for key, prefix := range ws.subs {
+		if m.matches(key, signal) || (prefix && strings.HasPrefix(signal, key)) {
+			match = key
+			break
+		}
+	}
+	ws.mutex.Unlock()
+	if match == "" {
+		return
+	}
+	select {
+	case ws.send <- WebSocketMessage{Type: "signal", Key: match, Signal: signal}:
+	case <-ws.closing:
+	default:
+		ws.close(wsCloseTryAgainLater, "send queue full")
+	}
+}
+
+func (ws *wsConn) readLoop() {
+	defer ws.close(wsCloseNormal, "")
+	var message []byte
+	var messageOp byte
+	for {
+		ws.conn.SetReadDeadline(time.Now().Add(ws.config.PingInterval + ws.config.PongTimeout))
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			if err == errFrameTooLarge {
+				ws.close(wsCloseTooBig, "message too large")
+			} else if err == errProtocol {
+				ws.close(wsCloseProtocolError, "protocol error")
+			}
+			return
+		}
+		switch op {
+		case wsPing:
+			ws.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			ws.close(code, "")
+			return
+		case wsText, wsBinary:
+			if messageOp != 0 {
+				ws.close(wsCloseProtocolError, "unexpected data frame")
+				return
+			}
+			messageOp, message = op, payload
+		case wsContinuation:
+			if messageOp == 0 {
+				ws.close(wsCloseProtocolError, "unexpected continuation frame")
+				return
+			}
+			message = append(message, payload...)
+		default:
+			ws.close(wsCloseProtocolError, "unknown opcode")
+			return
+		}
+		if int64(len(message)) > ws.config.MaxMessageSize {
+			ws.close(wsCloseTooBig, "message too large")
+			return
+		}
+		if !fin {
+			continue
+		}
+		if messageOp != wsText {
+			ws.close(wsCloseUnsupported, "text messages only")
+			return
+		}
+		ws.handle(message)
+		message, messageOp = nil, 0
+	}
+}
+
+func (ws *wsConn) handle(bs []byte) {
+	var msg WebSocketMessage
+	if err := json.Unmarshal(bs, &msg); err != nil {
+		ws.reply(WebSocketMessage{Type: "error", Error: "invalid message"})
+		return
+	}
+	if msg.Key == "" {
+		ws.reply(WebSocketMessage{Type: "error", Error: "key is required"})
+		return
+	}
+	switch msg.Type {
+	case "subscribe":
+		ws.mutex.Lock()
+		_, exists := ws.subs[msg.Key]
+		full := !exists && len(ws.subs) >= ws.config.MaxSubscriptions
+		if !full {
+			ws.subs[msg.Key] = msg.Prefix
+		}
+		ws.mutex.Unlock()
+		if full {
+			ws.reply(WebSocketMessage{Type: "error", Key: msg.Key, Error: "too many subscriptions"})
+			return
+		}
+		ws.reply(WebSocketMessage{Type: "subscribed", Key: msg.Key, Prefix: msg.Prefix})
+	case "unsubscribe":
+		ws.mutex.Lock()
+		delete(ws.subs, msg.Key)
+		ws.mutex.Unlock()
+		ws.reply(WebSocketMessage{Type: "unsubscribed", Key: msg.Key})
+	default:
+		ws.reply(WebSocketMessage{Type: "error", Key: msg.Key, Error: fmt.Sprintf("unknown message type %q", msg.Type)})
+	}
+}
+
+// reply queues a response, disconnecting a client that does not read them.
+func (ws *wsConn) reply(msg WebSocketMessage) {
+	select {
+	case ws.send <- msg:
+	case <-ws.closing:
+	default:
+		ws.close(wsCloseTryAgainLater, "send queue full")
+	}
+}
+
+// writeLoop is the only writer of messages. It also sends the close frame and
+// closes the connection, so that closing never blocks other goroutines.
+func (ws *wsConn) writeLoop(shutdown <-chan struct{}) {
+	ping := time.NewTicker(ws.config.PingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ws.closing:
+			payload := make([]byte, 2, 2+len(ws.closeReason))
+			binary.BigEndian.PutUint16(payload, uint16(ws.closeCode))
+			payload = append(payload, ws.closeReason...)
+			ws.writeFrame(wsClose, payload)
+			ws.conn.Close()
+			return
+		case <-shutdown:
+			ws.close(wsCloseGoingAway, "server is shutting down")
+			shutdown = nil
+		case msg := <-ws.send:
+			bs, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			if err := ws.writeFrame(wsText, bs); err != nil {
+				ws.close(wsCloseNormal, "")
+			}
+		case <-ping.C:
+			if err := ws.writeFrame(wsPing, nil); err != nil {
+				ws.close(wsCloseNormal, "")
+			}
+		}
+	}
+}
+
+// close asks writeLoop to close the connection with code. It does not block
+// and only the first call has an effect.
+func (ws *wsConn) close(code int, reason string) {
+	ws.closeOnce.Do(func() {
+		ws.closeCode = code
+		ws.closeReason = reason
+		close(ws.closing)
+	})
+}
+
+var (
+	errFrameTooLarge = errors.New("websocket: frame too large")
+	errProtocol      = errors.New("websocket: protocol error")
+)
+
+func (ws *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(ws.rw, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		// no extensions are negotiated and clients must mask
+		err = errProtocol
+		return
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.rw, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.rw, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsClose && (!fin || length > 125) {
+		err = errProtocol
+		return
+	}
+	if length > uint64(ws.config.MaxMessageSize) {
+		err = errFrameTooLarge
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.rw, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.rw, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	ws.writeLock.Lock()
+	defer ws.writeLock.Unlock()
+	head := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n <= 125:
+		head = append(head, byte(n))
+	case n <= 0xFFFF:
+		head = append(head, 126, byte(n>>8), byte(n))
+	default:
+		head = append(head, 127)
+		head = append(head, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.config.PongTimeout))
+	if _, err := ws.rw.Write(head); err != nil {
+		return err
+	}
+	if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+	return ws.rw.Flush()
+}
@@ -0,0 +1,418 @@
+package mux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type wsTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, url string) *wsTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	// the example from RFC 6455 section 1.3
+	if got := resp.Header.Get(HeaderSecWebSocketAccept); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept %q", got)
+	}
+	return &wsTestClient{t: t, conn: conn, r: r}
+}
+
+// writeFrame writes a masked frame.
+func (c *wsTestClient) writeFrame(fin bool, op byte, payload []byte) {
+	c.t.Helper()
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	head := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		head = append(head, 0x80|byte(n))
+	case n <= 0xFFFF:
+		head = append(head, 0x80|126, byte(n>>8), byte(n))
+	default:
+		head = append(head, 0x80|127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	if _, err := c.conn.Write(append(append(head, mask...), masked...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsTestClient) send(msg string) {
+	c.writeFrame(true, wsText, []byte(msg))
+}
+
+func (c *wsTestClient) readFrame() (byte, []byte) {
+	c.t.Helper()
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, head); err != nil {
+		c.t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		c.t.Fatal("server frames must not be masked")
+	}
+	n := int(head[1] & 0x7F)
+	if n == 126 {
+		ext := make([]byte, 2)
+		io.ReadFull(c.r, ext)
+		n = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+func (c *wsTestClient) readMessage() WebSocketMessage {
+	c.t.Helper()
+	op, payload := c.readFrame()
+	if op != wsText {
+		c.t.Fatalf("expected text frame, got opcode %d %q", op, payload)
+	}
+	var msg WebSocketMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+func (c *wsTestClient) expectClose(code int) {
+	c.t.Helper()
+	op, payload := c.readFrame()
+	if op != wsClose {
+		c.t.Fatalf("expected close frame, got opcode %d %q", op, payload)
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		c.t.Fatalf("expected close code %d, got %d (%s)", code, got, payload[2:])
+	}
+}
+
+func newWebSocketServer(t *testing.T, c WebSocketConfig) (*Manager, *httptest.Server) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, httptest.NewServer(m.WebSocket(c))
+}
+
+func TestWebSocketHandshakeRejected(t *testing.T) {
+	_, srv := newWebSocketServer(t, WebSocketConfig{CheckOrigin: func(r *http.Request) bool {
+		return r.Header.Get(HeaderOrigin) != "https://evil.example"
+	}})
+	defer srv.Close()
+	upgrade := map[string]string{
+		HeaderUpgrade:             "websocket",
+		HeaderConnection:          "Upgrade",
+		HeaderSecWebSocketVersion: "13",
+		HeaderSecWebSocketKey:     "dGhlIHNhbXBsZSBub25jZQ==",
+	}
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"plain request", map[string]string{}, http.StatusBadRequest},
+		{"old version", map[string]string{HeaderSecWebSocketVersion: "8"}, http.StatusUpgradeRequired},
+		{"invalid key", map[string]string{HeaderSecWebSocketKey: "short"}, http.StatusBadRequest},
+		{"origin", map[string]string{HeaderOrigin: "https://evil.example"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if len(tt.header) > 0 {
+				for k, v := range upgrade {
+					req.Header.Set(k, v)
+				}
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestWebSocketCrossOriginRejectedByDefault(t *testing.T) {
+	_, srv := newWebSocketServer(t, WebSocketConfig{})
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set(HeaderUpgrade, "websocket")
+	req.Header.Set(HeaderConnection, "Upgrade")
+	req.Header.Set(HeaderSecWebSocketVersion, "13")
+	req.Header.Set(HeaderSecWebSocketKey, "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set(HeaderOrigin, "https://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://api.example.com", true},
+		{"http://API.example.com", true},
+		{"https://api.example.com:8443", false},
+		{"https://evil.example", false},
+		{"null", false},
+		{"://", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://api.example.com/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set(HeaderOrigin, tt.origin)
+		}
+		if got := SameOrigin(r); got != tt.want {
+			t.Errorf("SameOrigin with origin %q: expected %v, got %v", tt.origin, tt.want, got)
+		}
+		if !AllowAllOrigins(r) {
+			t.Errorf("AllowAllOrigins rejected origin %q", tt.origin)
+		}
+	}
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	m, srv := newWebSocketServer(t, WebSocketConfig{})
+	defer srv.Close()
+	c := dialWebSocket(t, srv.URL)
+	defer c.conn.Close()
+
+	c.send(`{"type":"subscribe","key":"/items/1"}`)
+	if msg := c.readMessage(); msg.Type != "subscribed" || msg.Key != "/items/1" {
+		t.Fatalf("unexpected %+v", msg)
+	}
+	c.send(`{"type":"subscribe","key":"/orders","prefix":true}`)
+	if msg := c.readMessage(); msg.Type != "subscribed" || !msg.Prefix {
+		t.Fatalf("unexpected %+v", msg)
+	}
+
+	m.Signal("/items")
+	if msg := c.readMessage(); msg.Type != "signal" || msg.Key != "/items/1" || msg.Signal != "/items" {
+		t.Fatalf("unexpected %+v", msg)
+	}
+	m.Signal("/other")
+	m.Signal("/orders/7")
+	if msg := c.readMessage(); msg.Type != "signal" || msg.Key != "/orders" || msg.Signal != "/orders/7" {
+		t.Fatalf("unexpected %+v", msg)
+	}
+
+	c.send(`{"type":"unsubscribe","key":"/items/1"}`)
+	if msg := c.readMessage(); msg.Type != "unsubscribed" {
+		t.Fatalf("unexpected %+v", msg)
+	}
+	m.Signal("/items")
+	c.send(`{"type":"bogus","key":"/x"}`)
+	if msg := c.readMessage(); msg.Type != "error" {
+		t.Fatalf("expected an error instead of a signal, got %+v", msg)
+	}
+}
+
+func TestWebSocketMaxSubscriptions(t *testing.T) {
+	_, srv := newWebSocketServer(t, WebSocketConfig{MaxSubscriptions: 1})
+	defer srv.Close()
+	c := dialWebSocket(t, srv.URL)
+	defer c.conn.Close()
+	c.send(`{"type":"subscribe","key":"/a"}`)
+	c.readMessage()
+	c.send(`{"type":"subscribe","key":"/b"}`)
+	if msg := c.readMessage(); msg.Type != "error" || msg.Key != "/b" {
+		t.Fatalf("unexpected %+v", msg)
+	}
+}
+
+func TestWebSocketFragmentedMessage(t *testing.T) {
+	_, srv := newWebSocketServer(t, WebSocketConfig{})
+	defer srv.Close()
+	c := dialWebSocket(t, srv.URL)
+	defer c.conn.Close()
+	msg := []byte(`{"type":"subscribe","key":"/fragmented"}`)
+	c.writeFrame(false, wsText, msg[:10])
+	// control frames may be interleaved with fragments
+	c.writeFrame(true, wsPing, []byte("p"))
+	c.writeFrame(false, wsContinuation, msg[10:20])
+	c.writeFrame(true, wsContinuation, msg[20:])
+
+	if op, payload := c.readFrame(); op != wsPong || string(payload) != "p" {
+		t.Fatalf("expected pong, got opcode %d %q", op, payload)
+	}
+	if got := c.readMessage(); got.Type != "subscribed" || got.Key != "/fragmented" {
+		t.Fatalf("unexpected %+v", got)
+	}
+}
+
+func TestWebSocketExtendedLength(t *testing.T) {
+	_, srv := newWebSocketServer(t, WebSocketConfig{MaxMessageSize: 1 << 10})
+	defer srv.Close()
+	c := dialWebSocket(t, srv.URL)
+	defer c.conn.Close()
+	key := "/" + strings.Repeat("k", 300)
+	c.send(`{"type":"subscribe","key":"` + key + `"}`)
+	if got := c.readMessage(); got.Key != key {
+		t.Fatalf("unexpected key of length %d", len(got.Key))
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(c *wsTestClient)
+		code  int
+	}{
+		{"oversized frame", func(c *wsTestClient) {
+			c.writeFrame(true, wsText, make([]byte, 65))
+		}, wsCloseTooBig},
+		{"oversized fragments", func(c *wsTestClient) {
+			c.writeFrame(false, wsText, make([]byte, 40))
+			c.writeFrame(true, wsContinuation, make([]byte, 40))
+		}, wsCloseTooBig},
+		{"long control frame", func(c *wsTestClient) {
+			c.writeFrame(true, wsPing, make([]byte, 126))
+		}, wsCloseProtocolError},
+		{"fragmented control frame", func(c *wsTestClient) {
+			c.writeFrame(false, wsPing, nil)
+		}, wsCloseProtocolError},
+		{"unexpected continuation", func(c *wsTestClient) {
+			c.writeFrame(true, wsContinuation, []byte("x"))
+		}, wsCloseProtocolError},
+		{"interleaved data frame", func(c *wsTestClient) {
+			c.writeFrame(false, wsText, []byte("x"))
+			c.writeFrame(true, wsText, []byte("y"))
+		}, wsCloseProtocolError},
+		{"unknown opcode", func(c *wsTestClient) {
+			c.writeFrame(true, 0x3, nil)
+		}, wsCloseProtocolError},
+		{"unmasked frame", func(c *wsTestClient) {
+			c.conn.Write([]byte{0x81, 0x01, 'x'})
+		}, wsCloseProtocolError},
+		{"binary message", func(c *wsTestClient) {
+			c.writeFrame(true, wsBinary, []byte{1})
+		}, wsCloseUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv := newWebSocketServer(t, WebSocketConfig{MaxMessageSize: 64})
+			defer srv.Close()
+			c := dialWebSocket(t, srv.URL)
+			defer c.conn.Close()
+			tt.write(c)
+			c.expectClose(tt.code)
+		})
+	}
+}
+
+func TestWebSocketClientClose(t *testing.T) {
+	_, srv := newWebSocketServer(t, WebSocketConfig{})
+	defer srv.Close()
+	c := dialWebSocket(t, srv.URL)
+	defer c.conn.Close()
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, wsCloseGoingAway)
+	c.writeFrame(true, wsClose, payload)
+	c.expectClose(wsCloseGoingAway)
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	_, srv := newWebSocketServer(t, WebSocketConfig{PingInterval: 20 * time.Millisecond})
+	defer srv.Close()
+	c := dialWebSocket(t, srv.URL)
+	defer c.conn.Close()
+	if op, _ := c.readFrame(); op != wsPing {
+		t.Fatalf("expected ping, got opcode %d", op)
+	}
+}
+
+func TestWebSocketManagerClose(t *testing.T) {
+	m, srv := newWebSocketServer(t, WebSocketConfig{})
+	defer srv.Close()
+	c := dialWebSocket(t, srv.URL)
+	defer c.conn.Close()
+	c.send(`{"type":"subscribe","key":"/a"}`)
+	c.readMessage()
+	m.Close()
+	c.expectClose(wsCloseGoingAway)
+}
+
+func TestWebSocketSlowClientDoesNotBlockSignals(t *testing.T) {
+	m, err := NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	// net.Pipe is unbuffered, so the client not reading stalls every write
+	ws := &wsConn{
+		conn:    server,
+		rw:      bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)),
+		config:  WebSocketConfig{PingInterval: time.Hour, PongTimeout: 200 * time.Millisecond},
+		subs:    map[string]bool{"/a": false},
+		send:    make(chan WebSocketMessage, 1),
+		closing: make(chan struct{}),
+	}
+	go ws.writeLoop(nil)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		ws.notify(m, "/a")
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("notify blocked for %s", d)
+	}
+	select {
+	case <-ws.closing:
+	default:
+		t.Fatal("expected the slow client to be closed")
+	}
+	if ws.closeCode != wsCloseTryAgainLater {
+		t.Fatalf("unexpected close code %d", ws.closeCode)
+	}
+}